@@ -0,0 +1,86 @@
+// +build linux
+
+package main
+
+import (
+	"gitea.icts.kuleuven.be/ceif-lnx/go-nhc/utils"
+	linuxproc "github.com/c9s/goprocinfo/linux"
+)
+
+// Information shared between checks is read once, when it is first needed.
+// Checks that timed out keep running in the background, so access is serialized
+
+func (c *Context) Mounts() (*linuxproc.Mounts, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.mounts == nil {
+		mounts, err := linuxproc.ReadMounts("/proc/mounts")
+		if err != nil {
+			return nil, err
+		}
+		c.mounts = mounts
+	}
+
+	return c.mounts, nil
+}
+
+func (c *Context) MemInfo() (*linuxproc.MemInfo, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.memInfo == nil {
+		memInfo, err := linuxproc.ReadMemInfo(meminfo_file)
+		if err != nil {
+			return nil, err
+		}
+		c.memInfo = memInfo
+	}
+
+	return c.memInfo, nil
+}
+
+func (c *Context) CPUInfo() (*linuxproc.CPUInfo, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.cpuInfo == nil {
+		cpuInfo, err := linuxproc.ReadCPUInfo(cpuinfo_file)
+		if err != nil {
+			return nil, err
+		}
+		c.cpuInfo = cpuInfo
+	}
+
+	return c.cpuInfo, nil
+}
+
+func (c *Context) Processes() ([]*linuxproc.ProcessStatus, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.psInfo == nil {
+		psInfo, err := utils.ListProcesses()
+		if err != nil {
+			return nil, err
+		}
+		c.psInfo = psInfo
+	}
+
+	return c.psInfo, nil
+}
+
+func (c *Context) Jobs() ([]utils.Job, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.jobInfo == nil {
+		jobInfo, err := utils.ListPBSJobs()
+		if err != nil {
+			return nil, err
+		}
+		c.jobInfo = jobInfo
+	}
+
+	return c.jobInfo, nil
+}
@@ -1,9 +1,73 @@
 package main
 
-type CheckFactory func(string) (Check, error)
-type Check func() (Status, string)
+import (
+	"time"
+)
+
+// Creates a check from its argument, from which the common metadata has been
+// stripped, see ParseCommonMetadata. Besides the check, it returns its parsed
+// metadata, if any, e.g. to explain the check
+type CheckFactory func(argument string, common CommonMetadata) (Check, interface{}, error)
+type Check func(r *Report) (Status, string)
 type Status int
 
+type Result struct {
+	Label    string    `json:"label"`
+	Type     string    `json:"type"`
+	Argument string    `json:"argument"`
+	Status   Status    `json:"status"`
+	Message  string    `json:"message"`
+	Metrics  []Metric  `json:"metrics,omitempty"`
+	Time     time.Time `json:"time"`
+	Since    time.Time `json:"since,omitempty"`
+	Changed  bool      `json:"changed,omitempty"`
+
+	// Counters to keep in the state until the next run, not sent
+	Counters map[string]uint64 `json:"-"`
+}
+
+// What a check reports besides its status and message. Each run of a check
+// gets its own report, so that a check that timed out and is still running in
+// the background can't interfere with the checks that run after it
+type Report struct {
+	Metrics  []Metric
+	Counters map[string]uint64
+	Fatal    bool // Whether the result stays fatal with fatal=no, see KeepFatal
+
+	previous     map[string]uint64
+	previousTime time.Time
+}
+
+// Report a metric
+func (r *Report) AddMetric(metric Metric) {
+	r.Metrics = append(r.Metrics, metric)
+}
+
+// Return the counters stored by the check during its previous run, and the
+// time of that run. Without state, nil is returned
+func (r *Report) PreviousCounters() (map[string]uint64, time.Time) {
+	return r.previous, r.previousTime
+}
+
+// Keep the result fatal if the check is not, e.g. a process that runs as the
+// wrong user is critical, even if it is not fatal that the process is missing
+func (r *Report) KeepFatal() {
+	r.Fatal = true
+}
+
+// Store counters, to be returned by PreviousCounters during the next run
+func (r *Report) StoreCounters(counters map[string]uint64) {
+	r.Counters = counters
+}
+
+// An empty report with the same previous counters, to run a check in the background
+func (r *Report) fork() *Report {
+	return &Report{
+		previous:     r.previous,
+		previousTime: r.previousTime,
+	}
+}
+
 const (
 	OK       Status = 0
 	Warning  Status = 1
@@ -28,6 +92,20 @@ func (s Status) String() string {
 	return "UNKNOWN"
 }
 
+// Status as used in the output of nagios plugins
+func (s Status) NagiosString() string {
+	if s == OK {
+		return "OK"
+	}
+	if s == Warning {
+		return "WARNING"
+	}
+	if s == Critical {
+		return "CRITICAL"
+	}
+	return "UNKNOWN"
+}
+
 func (s Status) RC() int {
 	return int(s)
 }
@@ -47,14 +125,6 @@ func (s Status) NonFatal() Status {
 	}
 }
 
-// Translate a Status in a non-fatal one if mayBeFatal is false
-func (s Status) NonFatalUnless(mayBeFatal bool) Status {
-	if mayBeFatal {
-		return s
-	}
-	return s.NonFatal()
-}
-
 // Compare two - order Critical > Warning > Unknown > OK > Ignore
 func (s Status) Compare(t Status) int {
 	if s == t {
@@ -4,11 +4,12 @@ package main
 
 import (
 	"fmt"
-	"io/ioutil"
+	"io"
 	"net"
 	"os"
 	"os/exec"
 	"os/user"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
@@ -24,16 +25,29 @@ const (
 	meminfo_file = "/proc/meminfo"
 )
 
-func (c *Context) CheckInterface(iface string) (Check, error) {
-	return func() (Status, string) {
-		if iface == "lo" {
-			return AssureExists(fmt.Sprintf("/sys/class/net/%s", iface))
+type InterfaceMetadata struct {
+	Interface string
+}
+
+func (c *Context) CheckInterface(argument string, common CommonMetadata) (Check, interface{}, error) {
+	m := &InterfaceMetadata{}
+	err := ParseMetadata(m, argument, "Interface")
+	if err != nil {
+		return nil, nil, err
+	}
+	if m.Interface == "" {
+		return nil, nil, fmt.Errorf("no interface given")
+	}
+
+	return func(r *Report) (Status, string) {
+		if m.Interface == "lo" {
+			return AssureExists(fmt.Sprintf("/sys/class/net/%s", m.Interface))
 		}
 
 		reUP := regexp.MustCompile(`up`)
 
-		return AssureContent(fmt.Sprintf("/sys/class/net/%s/operstate", iface), reUP)
-	}, nil
+		return AssureContent(fmt.Sprintf("/sys/class/net/%s/operstate", m.Interface), reUP)
+	}, m, nil
 }
 
 type InfinibandMetadata struct {
@@ -42,6 +56,13 @@ type InfinibandMetadata struct {
 	Speed    int
 	Warning  uint64
 	Critical uint64
+	Counters string
+}
+
+type InfinibandCounter struct {
+	Name     string
+	Warning  uint64
+	Critical uint64
 }
 
 var (
@@ -55,299 +76,1446 @@ var (
 	}
 )
 
-func (c *Context) CheckInfiniband(argument string) (Check, error) {
+// Parse a list of counters, format: '<NAME>[:<WARNING>[:<CRITICAL>]],...'
+// Thresholds that are omitted default to the given ones
+func ParseInfinibandCounters(list string, warning uint64, critical uint64) ([]InfinibandCounter, error) {
+	result := []InfinibandCounter{}
+
+	for _, item := range strings.Split(list, ",") {
+		if item == "" {
+			continue
+		}
+
+		parts := strings.Split(item, ":")
+		if len(parts) > 3 {
+			return nil, fmt.Errorf("expected COUNTER[:WARNING[:CRITICAL]] got '%s'", item)
+		}
+
+		counter := InfinibandCounter{
+			Name:     parts[0],
+			Warning:  warning,
+			Critical: critical,
+		}
+
+		var err error
+		if len(parts) > 1 && parts[1] != "" {
+			counter.Warning, err = strconv.ParseUint(parts[1], 10, 64)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if len(parts) > 2 && parts[2] != "" {
+			counter.Critical, err = strconv.ParseUint(parts[2], 10, 64)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		result = append(result, counter)
+	}
+
+	return result, nil
+}
+
+// Locate a counter of an infiniband or omni-path port. Counters are looked up
+// in the port's counters and hw_counters directory, and in the device's
+// hw_counters directory (used by e.g. hfi1)
+func FindInfinibandCounter(device string, port int, counter string) (string, error) {
+	candidates := []string{
+		fmt.Sprintf("/sys/class/infiniband/%s/ports/%d/counters/%s", device, port, counter),
+		fmt.Sprintf("/sys/class/infiniband/%s/ports/%d/hw_counters/%s", device, port, counter),
+		fmt.Sprintf("/sys/class/infiniband/%s/hw_counters/%s", device, counter),
+	}
+
+	for _, file := range candidates {
+		if _, err := os.Stat(file); err == nil {
+			return file, nil
+		}
+	}
+
+	return "", fmt.Errorf("counter %s not found for %s port %d", counter, device, port)
+}
+
+func (c *Context) CheckInfiniband(argument string, common CommonMetadata) (Check, interface{}, error) {
 	m := &InfinibandMetadata{
 		Port:     1,
 		Warning:  10,
 		Critical: 100,
+		Counters: strings.Join(infinibandErrorCounters, ","),
 	}
 	err := ParseMetadata(m, argument, "Device")
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	parts := strings.SplitN(m.Device, ":", 2)
+	if len(parts) == 2 {
+		m.Device = parts[0]
+		m.Port, err = strconv.Atoi(parts[1])
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	counters, err := ParseInfinibandCounters(m.Counters, m.Warning, m.Critical)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	state_re := regexp.MustCompile("4: ACTIVE")
+	speed_re, err := regexp.Compile(fmt.Sprintf("^%d\\s", m.Speed))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return func(r *Report) (Status, string) {
+		file := fmt.Sprintf("/sys/class/infiniband/%s/ports/%d/state", m.Device, m.Port)
+		status, message := AssureContent(file, state_re)
+		if status != OK {
+			return status, message
+		}
+
+		file = fmt.Sprintf("/sys/class/infiniband/%s/ports/%d/rate", m.Device, m.Port)
+		status, message = AssureContent(file, speed_re)
+		if status != OK {
+			return status, message
+		}
+
+		message = ""
+
+		for _, counter := range counters {
+			file, err := FindInfinibandCounter(m.Device, m.Port, counter.Name)
+			if err != nil {
+				return Unknown, fmt.Sprintf("Could not find port counter: %s", err.Error())
+			}
+
+			i, err := utils.ReadUint(file)
+			if err != nil {
+				return Unknown, fmt.Sprintf("Could not read port counter %s: %s", counter.Name, err.Error())
+			}
+
+			r.AddMetric(Metric{
+				Name:     counter.Name,
+				Value:    float64(i),
+				Unit:     "c",
+				Warning:  fmt.Sprintf("%d", counter.Warning),
+				Critical: fmt.Sprintf("%d", counter.Critical),
+			})
+
+			if i >= counter.Critical && status != Critical {
+				status = Critical
+				message = fmt.Sprintf("Port counter %s is higher than threshold %d: %d", counter.Name, counter.Critical, i)
+			} else if i >= counter.Warning && status == OK {
+				status = Warning
+				message = fmt.Sprintf("Port counter %s is higher than threshold %d: %d", counter.Name, counter.Warning, i)
+			}
+		}
+
+		return status, message
+	}, m, nil
+}
+
+type MountMetadata struct {
+	MountPoint string
+	Device     string
+	FsType     string
+	ReadOnly   bool
+	Remount    bool
+}
+
+func (c *Context) CheckMount(argument string, common CommonMetadata) (Check, interface{}, error) {
+	m := &MountMetadata{}
+	err := ParseMetadata(m, argument, "MountPoint")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return func(r *Report) (Status, string) {
+		mounts, err := c.Mounts()
+		if err != nil {
+			return Unknown, fmt.Sprintf("Could not parse mounts: %s", err.Error())
+		}
+
+		for _, mount := range mounts.Mounts {
+			if mount.MountPoint == m.MountPoint && mount.FSType != "autofs" {
+				if m.Device != "" && mount.Device != m.Device {
+					return Critical, fmt.Sprintf("Mount point %s does not match required device %s: %s", m.MountPoint, m.Device, mount.Device)
+				}
+				if m.FsType != "" && mount.FSType != m.FsType {
+					return Warning, fmt.Sprintf("Mount point %s does not match required fstype %s: %s", m.MountPoint, m.FsType, mount.FSType)
+				}
+				if !m.ReadOnly && mount.Options != "rw" && !strings.HasPrefix(mount.Options, "rw,") {
+					return Critical, fmt.Sprintf("Mount point %s is not mounted read-write: %s", m.MountPoint, mount.Options)
+				}
+
+				return OK, ""
+			}
+		}
+
+		if m.Remount {
+			cmd := exec.Command("/usr/bin/mount", m.MountPoint)
+			err = cmd.Run()
+			if err != nil {
+				return Critical, fmt.Sprintf("Mount point %s is not mounted, remount failed: %s", m.MountPoint, err)
+			}
+			return Warning, fmt.Sprintf("Mount point %s was not mounted, did remount it", m.MountPoint)
+		}
+
+		return Critical, fmt.Sprintf("Mount point %s is not mounted", m.MountPoint)
+	}, m, nil
+}
+
+type FileMetadata struct {
+	File     string
+	Owner    string
+	Group    string
+	Mode     string
+	Type     string
+	MinSize  bytesize.ByteSize
+	MaxSize  bytesize.ByteSize
+	MaxAge   time.Duration
+	Contains string
+	Sha256   string
+}
+
+func (c *Context) CheckFile(argument string, common CommonMetadata) (Check, interface{}, error) {
+	m := &FileMetadata{}
+	err := ParseMetadata(m, argument, "File")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var mode uint32
+	if m.Mode != "" {
+		mode, err = ParseMode(m.Mode)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if m.Type != "" {
+		err = ParseFileType(m.Type)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var contains_re *regexp.Regexp
+	if m.Contains != "" {
+		contains_re, err = regexp.Compile(m.Contains)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return func(r *Report) (Status, string) {
+		var info os.FileInfo
+		var err error
+
+		if m.Type == "symlink" {
+			info, err = os.Lstat(m.File)
+		} else {
+			info, err = os.Stat(m.File)
+		}
+		if err != nil {
+			return Critical, fmt.Sprintf("File %s missing: %s", m.File, err.Error())
+		}
+
+		if m.Type != "" {
+			if status, message := AssureType(m.File, info, m.Type); status != OK {
+				return status, message
+			}
+		}
+
+		if status, message := AssureOwnership(m.File, info, m.Owner, m.Group); status != OK {
+			return status, message
+		}
+
+		if m.Mode != "" {
+			if status, message := AssureMode(m.File, info, mode); status != OK {
+				return status, message
+			}
+		}
+
+		if m.MinSize > 0 && info.Size() < int64(m.MinSize) {
+			bs := bytesize.ByteSize(info.Size())
+			return Critical, fmt.Sprintf("File %s is smaller than %s: %s", m.File, m.MinSize.String(), bs.String())
+		}
+
+		if m.MaxSize > 0 && info.Size() > int64(m.MaxSize) {
+			bs := bytesize.ByteSize(info.Size())
+			return Critical, fmt.Sprintf("File %s is larger than %s: %s", m.File, m.MaxSize.String(), bs.String())
+		}
+
+		if m.MaxAge > 0 {
+			age := time.Since(info.ModTime())
+			if age > m.MaxAge {
+				return Critical, fmt.Sprintf("File %s is older than %s: last modified %s ago", m.File, m.MaxAge.String(), age.Truncate(time.Second).String())
+			}
+		}
+
+		if m.Sha256 != "" {
+			if status, message := AssureChecksum(m.File, m.Sha256); status != OK {
+				return status, message
+			}
+		}
+
+		if contains_re != nil {
+			return AssureContent(m.File, contains_re)
+		}
+
+		return OK, ""
+	}, m, nil
+}
+
+type SymlinkMetadata struct {
+	Path     string
+	Target   string
+	Resolves bool
+}
+
+func (c *Context) CheckSymlink(argument string, common CommonMetadata) (Check, interface{}, error) {
+	m := &SymlinkMetadata{
+		Resolves: true,
+	}
+	err := ParseMetadata(m, argument, "Path")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return func(r *Report) (Status, string) {
+		info, err := os.Lstat(m.Path)
+		if err != nil {
+			return Critical, fmt.Sprintf("Symlink %s missing: %s", m.Path, err.Error())
+		}
+
+		if info.Mode()&os.ModeSymlink == 0 {
+			return Critical, fmt.Sprintf("File %s is not a symlink: %s", m.Path, info.Mode().String())
+		}
+
+		target, err := os.Readlink(m.Path)
+		if err != nil {
+			return Unknown, fmt.Sprintf("Could not read symlink %s: %s", m.Path, err.Error())
+		}
+
+		if m.Target != "" && target != m.Target {
+			return Critical, fmt.Sprintf("Symlink %s does not point to %s: %s", m.Path, m.Target, target)
+		}
+
+		if m.Resolves {
+			if _, err := os.Stat(m.Path); err != nil {
+				return Critical, fmt.Sprintf("Symlink %s does not resolve: %s", m.Path, err.Error())
+			}
+		}
+
+		return OK, ""
+	}, m, nil
+}
+
+type DirectoryMetadata struct {
+	Path       string
+	Owner      string
+	Group      string
+	Mode       string
+	MinEntries int
+}
+
+func (c *Context) CheckDirectory(argument string, common CommonMetadata) (Check, interface{}, error) {
+	m := &DirectoryMetadata{}
+	err := ParseMetadata(m, argument, "Path")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var mode uint32
+	if m.Mode != "" {
+		mode, err = ParseMode(m.Mode)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return func(r *Report) (Status, string) {
+		info, err := os.Stat(m.Path)
+		if err != nil {
+			return Critical, fmt.Sprintf("Directory %s missing: %s", m.Path, err.Error())
+		}
+
+		if !info.IsDir() {
+			return Critical, fmt.Sprintf("File %s is not a directory: %s", m.Path, info.Mode().String())
+		}
+
+		if status, message := AssureOwnership(m.Path, info, m.Owner, m.Group); status != OK {
+			return status, message
+		}
+
+		if m.Mode != "" {
+			if status, message := AssureMode(m.Path, info, mode); status != OK {
+				return status, message
+			}
+		}
+
+		if m.MinEntries > 0 {
+			handle, err := os.Open(m.Path)
+			if err != nil {
+				return Unknown, fmt.Sprintf("Could not open directory %s: %s", m.Path, err.Error())
+			}
+			defer handle.Close()
+
+			// Only read as many entries as needed, directories can be huge
+			names, err := handle.Readdirnames(m.MinEntries)
+			if err != nil && err != io.EOF {
+				return Unknown, fmt.Sprintf("Could not read directory %s: %s", m.Path, err.Error())
+			}
+
+			if len(names) < m.MinEntries {
+				return Critical, fmt.Sprintf("Directory %s has less than %d entries: %d", m.Path, m.MinEntries, len(names))
+			}
+		}
+
+		return OK, ""
+	}, m, nil
+}
+
+func (c *Context) CheckFreeMemory(amount string, common CommonMetadata) (Check, interface{}, error) {
+	th, err := bytesize.Parse(amount)
+	if err != nil {
+		return nil, nil, err
+	}
+	return func(r *Report) (Status, string) {
+		memInfo, err := c.MemInfo()
+		if err != nil {
+			return Unknown, fmt.Sprintf("Could not parse meminfo: %s", err.Error())
+		}
+
+		// Values in meminfo are expressed in kB
+		r.AddMetric(Metric{
+			Name:     "available",
+			Value:    float64(memInfo.MemAvailable * 1024),
+			Unit:     "B",
+			Critical: fmt.Sprintf("%d:", uint64(th)*1024),
+			Min:      "0",
+			Max:      fmt.Sprintf("%d", memInfo.MemTotal*1024),
+		})
+
+		if memInfo.MemAvailable < uint64(th) {
+			bs := bytesize.ByteSize(memInfo.MemAvailable)
+			return Critical, fmt.Sprintf("Available memory is less than threshold %s: %s", th.String(), bs.String())
+		}
+
+		return OK, ""
+	}, nil, nil
+}
+
+func (c *Context) CheckFreeSwap(amount string, common CommonMetadata) (Check, interface{}, error) {
+	th, err := bytesize.Parse(amount)
+	if err != nil {
+		return nil, nil, err
+	}
+	return func(r *Report) (Status, string) {
+		memInfo, err := c.MemInfo()
+		if err != nil {
+			return Unknown, fmt.Sprintf("Could not parse meminfo: %s", err.Error())
+		}
+
+		// Values in meminfo are expressed in kB
+		r.AddMetric(Metric{
+			Name:    "free",
+			Value:   float64(memInfo.SwapFree * 1024),
+			Unit:    "B",
+			Warning: fmt.Sprintf("%d:", uint64(th)*1024),
+			Min:     "0",
+			Max:     fmt.Sprintf("%d", memInfo.SwapTotal*1024),
+		})
+
+		if memInfo.SwapFree < uint64(th) {
+			bs := bytesize.ByteSize(memInfo.SwapFree)
+			return Warning, fmt.Sprintf("Free memory is less than threshold %s: %s", th.String(), bs.String())
+		}
+
+		return OK, ""
+	}, nil, nil
+}
+
+func (c *Context) CheckFreeTotalMemory(amount string, common CommonMetadata) (Check, interface{}, error) {
+	th, err := bytesize.Parse(amount)
+	if err != nil {
+		return nil, nil, err
+	}
+	return func(r *Report) (Status, string) {
+		memInfo, err := c.MemInfo()
+		if err != nil {
+			return Unknown, fmt.Sprintf("Could not parse meminfo: %s", err.Error())
+		}
+
+		// Values in meminfo are expressed in kB
+		total := memInfo.MemAvailable + memInfo.SwapFree
+
+		r.AddMetric(Metric{
+			Name:     "available",
+			Value:    float64(total * 1024),
+			Unit:     "B",
+			Critical: fmt.Sprintf("%d:", uint64(th)*1024),
+			Min:      "0",
+			Max:      fmt.Sprintf("%d", (memInfo.MemTotal+memInfo.SwapTotal)*1024),
+		})
+		if total < uint64(th) {
+			bs := bytesize.ByteSize(total)
+			return Critical, fmt.Sprintf("Available memory is less than threshold %s: %s", th.String(), bs.String())
+		}
+
+		return OK, ""
+	}, nil, nil
+}
+
+type MemoryTotalMetadata struct {
+	Min bytesize.ByteSize
+	Max bytesize.ByteSize
+}
+
+func (c *Context) CheckMemoryTotal(argument string, common CommonMetadata) (Check, interface{}, error) {
+	m := &MemoryTotalMetadata{}
+	err := ParseMetadata(m, argument, "Min")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return func(r *Report) (Status, string) {
+		memInfo, err := c.MemInfo()
+		if err != nil {
+			return Unknown, fmt.Sprintf("Could not parse meminfo: %s", err.Error())
+		}
+
+		// Values in meminfo are expressed in kB
+		total := memInfo.MemTotal * 1024
+		bs := bytesize.ByteSize(total)
+
+		metric := Metric{
+			Name:  "total",
+			Value: float64(total),
+			Unit:  "B",
+			Min:   "0",
+		}
+		if m.Min > 0 || m.Max > 0 {
+			metric.Critical = fmt.Sprintf("%d:", uint64(m.Min))
+			if m.Max > 0 {
+				metric.Critical += fmt.Sprintf("%d", uint64(m.Max))
+			}
+		}
+		r.AddMetric(metric)
+
+		if total < uint64(m.Min) {
+			return Critical, fmt.Sprintf("Installed memory is less than %s: %s", m.Min.String(), bs.String())
+		}
+
+		if m.Max > 0 && total > uint64(m.Max) {
+			return Critical, fmt.Sprintf("Installed memory is more than %s: %s", m.Max.String(), bs.String())
+		}
+
+		return OK, ""
+	}, m, nil
+}
+
+type NumaMetadata struct {
+	Nodes     int
+	Tolerance int
+}
+
+func (c *Context) CheckNuma(argument string, common CommonMetadata) (Check, interface{}, error) {
+	m := &NumaMetadata{
+		Tolerance: 5,
+	}
+	err := ParseMetadata(m, argument, "Nodes")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return func(r *Report) (Status, string) {
+		nodes, err := utils.ListNumaNodes()
+		if err != nil {
+			return Unknown, fmt.Sprintf("Could not parse numa node info: %s", err.Error())
+		}
+
+		if m.Nodes > 0 && len(nodes) != m.Nodes {
+			return Critical, fmt.Sprintf("Expected %d numa nodes, found %d", m.Nodes, len(nodes))
+		}
+
+		var sum uint64
+		for _, node := range nodes {
+			sum += node.MemTotal
+
+			r.AddMetric(Metric{
+				Name:  node.Name,
+				Value: float64(node.MemTotal),
+				Unit:  "B",
+				Min:   "0",
+			})
+		}
+
+		// Nodes without memory, e.g. of accelerators, are not taken into account
+		var count uint64
+		for _, node := range nodes {
+			if node.MemTotal > 0 {
+				count++
+			}
+		}
+		if count == 0 {
+			return Critical, "No numa node has memory"
+		}
+
+		average := sum / count
+		for _, node := range nodes {
+			if node.MemTotal == 0 {
+				continue
+			}
+
+			var deviation uint64
+			if node.MemTotal > average {
+				deviation = node.MemTotal - average
+			} else {
+				deviation = average - node.MemTotal
+			}
+
+			if deviation*100 > average*uint64(m.Tolerance) {
+				bs := bytesize.ByteSize(node.MemTotal)
+				avg := bytesize.ByteSize(average)
+				return Critical, fmt.Sprintf("Memory of numa node %s deviates more than %d%% from the average %s: %s", node.Name, m.Tolerance, avg.String(), bs.String())
+			}
+		}
+
+		return OK, ""
+	}, m, nil
+}
+
+type DimmsMetadata struct {
+	Mode       string
+	Count      int
+	Size       bytesize.ByteSize
+	PerChannel int
+}
+
+// Name of a dimm in messages, including its slot label if known
+func DimmName(channel utils.MemoryChannel, dimm utils.Dimm) string {
+	if dimm.Label == "" {
+		return fmt.Sprintf("%s/%s", channel.Name, dimm.Name)
+	}
+	return fmt.Sprintf("%s/%s (%s)", channel.Name, dimm.Name, dimm.Label)
+}
+
+func (c *Context) CheckDimms(argument string, common CommonMetadata) (Check, interface{}, error) {
+	m := &DimmsMetadata{
+		Mode: "consistent",
+	}
+	err := ParseMetadata(m, argument, "Mode")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if m.Mode != "consistent" {
+		return nil, nil, fmt.Errorf("Unknown mode %s", m.Mode)
+	}
+
+	return func(r *Report) (Status, string) {
+		channels, err := utils.ListMemoryChannels()
+		if err != nil {
+			return Unknown, fmt.Sprintf("Could not parse dimm info: %s", err.Error())
+		}
+
+		var count int
+		for _, channel := range channels {
+			count += len(channel.Dimms)
+		}
+
+		if m.Count > 0 && count != m.Count {
+			return Critical, fmt.Sprintf("Expected %d dimms, found %d", m.Count, count)
+		}
+
+		if m.PerChannel > 0 {
+			offending := []string{}
+			for _, channel := range channels {
+				if len(channel.Dimms) != m.PerChannel {
+					offending = append(offending, fmt.Sprintf("%s has %d", channel.Name, len(channel.Dimms)))
+				}
+			}
+			if len(offending) > 0 {
+				return Critical, fmt.Sprintf("Expected %d dimms per memory channel: %s", m.PerChannel, strings.Join(offending, ", "))
+			}
+		}
+
+		// Sizes in EDAC are expressed in MB
+		if m.Size > 0 {
+			offending := []string{}
+			for _, channel := range channels {
+				for _, dimm := range channel.Dimms {
+					if dimm.Size*1024*1024 != uint64(m.Size) {
+						bs := bytesize.ByteSize(dimm.Size * 1024 * 1024)
+						offending = append(offending, fmt.Sprintf("%s has %s", DimmName(channel, dimm), bs.String()))
+					}
+				}
+			}
+			if len(offending) > 0 {
+				return Critical, fmt.Sprintf("Expected dimms of %s: %s", m.Size.String(), strings.Join(offending, ", "))
+			}
+		}
+
+		if len(channels) == 0 {
+			return OK, ""
+		}
+
+		first := channels[0]
+		if len(first.Dimms) == 0 {
+			return Critical, fmt.Sprintf("First memory channel %s has no dimms", first.Name)
+		}
+
+		offending := []string{}
+		for _, channel := range channels[1:] {
+			if len(channel.Dimms) != len(first.Dimms) {
+				offending = append(offending, fmt.Sprintf("%s has %d", channel.Name, len(channel.Dimms)))
+			}
+		}
+		if len(offending) > 0 {
+			return Critical, fmt.Sprintf("Number of dimms differ per memory channel, %s has %d: %s", first.Name, len(first.Dimms), strings.Join(offending, ", "))
+		}
+
+		size := first.Dimms[0].Size
+		if size == 0 {
+			return Critical, fmt.Sprintf("First dimm %s has no size", DimmName(first, first.Dimms[0]))
+		}
+
+		for _, channel := range channels {
+			for _, dimm := range channel.Dimms {
+				if dimm.Size != size {
+					bs := bytesize.ByteSize(dimm.Size * 1024 * 1024)
+					offending = append(offending, fmt.Sprintf("%s has %s", DimmName(channel, dimm), bs.String()))
+				}
+			}
+		}
+		if len(offending) > 0 {
+			bs := bytesize.ByteSize(size * 1024 * 1024)
+			return Critical, fmt.Sprintf("Dimm sizes differ, %s has %s: %s", DimmName(first, first.Dimms[0]), bs.String(), strings.Join(offending, ", "))
+		}
+
+		return OK, ""
+	}, m, nil
+}
+
+type EccMetadata struct {
+	CeWarning      uint64
+	CeCritical     uint64
+	CeRateWarning  uint64
+	CeRateCritical uint64
+	CeRateWindow   time.Duration
+}
+
+// Key of the counters that holds the time at which they were sampled
+const eccSampleTimeKey = "sample_time"
+
+// An EDAC error counter of a dimm, or of a memory controller for errors that
+// could not be attributed to a dimm
+type EccCounter struct {
+	Name    string
+	Label   string
+	CECount uint64
+	UECount uint64
+}
+
+func (counter EccCounter) String() string {
+	if counter.Label == "" {
+		return counter.Name
+	}
+	return fmt.Sprintf("%s (%s)", counter.Name, counter.Label)
+}
+
+func ListEccCounters(channels []utils.MemoryChannel) []EccCounter {
+	result := []EccCounter{}
+
+	for _, channel := range channels {
+		for _, dimm := range channel.Dimms {
+			result = append(result, EccCounter{
+				Name:    fmt.Sprintf("%s/%s", channel.Name, dimm.Name),
+				Label:   dimm.Label,
+				CECount: dimm.CECount,
+				UECount: dimm.UECount,
+			})
+		}
+
+		result = append(result, EccCounter{
+			Name:    fmt.Sprintf("%s/noinfo", channel.Name),
+			Label:   "unknown dimm",
+			CECount: channel.CENoInfoCount,
+			UECount: channel.UENoInfoCount,
+		})
+	}
+
+	return result
+}
+
+func (c *Context) CheckEcc(argument string, common CommonMetadata) (Check, interface{}, error) {
+	m := &EccMetadata{
+		CeRateWarning:  10,
+		CeRateCritical: 100,
+		CeRateWindow:   time.Hour,
+	}
+	err := ParseMetadata(m, argument, "CeRateWarning")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return func(r *Report) (Status, string) {
+		channels, err := utils.ListMemoryChannels()
+		if err != nil {
+			return Unknown, fmt.Sprintf("Could not parse edac info: %s", err.Error())
+		}
+		if len(channels) == 0 {
+			return Unknown, "No memory controllers found, is the EDAC driver loaded?"
+		}
+
+		var ce, ue uint64
+		for _, channel := range channels {
+			ce += channel.CECount
+			ue += channel.UECount
+		}
+
+		r.AddMetric(Metric{
+			Name:  "ce_count",
+			Value: float64(ce),
+			Unit:  "c",
+		})
+		r.AddMetric(Metric{
+			Name:  "ue_count",
+			Value: float64(ue),
+			Unit:  "c",
+		})
+
+		counters := ListEccCounters(channels)
+
+		// The rate is measured against a reference sample that is kept until it
+		// is ce_rate_window old, and is calculated over at least that window, so
+		// that a single error does not result in a high rate if the check runs often
+		previous, sampled := r.PreviousCounters()
+		if t, ok := previous[eccSampleTimeKey]; ok {
+			sampled = time.Unix(int64(t), 0)
+		}
+		elapsed := time.Since(sampled)
+
+		window := elapsed
+		if window < m.CeRateWindow {
+			window = m.CeRateWindow
+		}
+
+		current := map[string]uint64{
+			eccSampleTimeKey: uint64(time.Now().Unix()),
+		}
+		for _, counter := range counters {
+			current[counter.Name] = counter.CECount
+		}
+
+		if previous != nil && elapsed < m.CeRateWindow {
+			reference := map[string]uint64{
+				eccSampleTimeKey: uint64(sampled.Unix()),
+			}
+			for name, value := range previous {
+				if name != eccSampleTimeKey {
+					reference[name] = value
+				}
+			}
+			r.StoreCounters(reference)
+		} else {
+			r.StoreCounters(current)
+		}
+
+		status := OK
+		message := ""
+
+		for _, counter := range counters {
+			if counter.UECount > 0 {
+				return Critical, fmt.Sprintf("Uncorrectable memory errors on %s: %d", counter.String(), counter.UECount)
+			}
+
+			if m.CeCritical > 0 && counter.CECount >= m.CeCritical && status != Critical {
+				status = Critical
+				message = fmt.Sprintf("Correctable memory errors on %s are higher than threshold %d: %d", counter.String(), m.CeCritical, counter.CECount)
+			} else if m.CeWarning > 0 && counter.CECount >= m.CeWarning && status == OK {
+				status = Warning
+				message = fmt.Sprintf("Correctable memory errors on %s are higher than threshold %d: %d", counter.String(), m.CeWarning, counter.CECount)
+			}
+
+			before, ok := previous[counter.Name]
+			if !ok || window <= 0 {
+				continue
+			}
+
+			// Counters are reset on reboot
+			increase := counter.CECount
+			if counter.CECount >= before {
+				increase = counter.CECount - before
+			}
+			rate := uint64(float64(increase) / window.Hours())
+
+			if m.CeRateCritical > 0 && rate >= m.CeRateCritical && status != Critical {
+				status = Critical
+				message = fmt.Sprintf("Correctable memory errors on %s increase faster than %d per hour: %d in %s", counter.String(), m.CeRateCritical, increase, elapsed.Truncate(time.Second).String())
+			} else if m.CeRateWarning > 0 && rate >= m.CeRateWarning && status == OK {
+				status = Warning
+				message = fmt.Sprintf("Correctable memory errors on %s increase faster than %d per hour: %d in %s", counter.String(), m.CeRateWarning, increase, elapsed.Truncate(time.Second).String())
+			}
+		}
+
+		// Some drivers only expose csrow counters, so errors do not show up in the
+		// counters of a dimm or in the noinfo counter, only in the controller total
+		for _, channel := range channels {
+			if channel.UECount > 0 {
+				return Critical, fmt.Sprintf("Uncorrectable memory errors on %s: %d", channel.Name, channel.UECount)
+			}
+		}
+
+		return status, message
+	}, m, nil
+}
+
+type HyperthreadingMetadata struct {
+	State   string
+	Control string
+}
+
+var (
+	smtControls = []string{"on", "off", "forceoff", "notsupported", "notimplemented"}
+)
+
+// Whether simultaneous multithreading is active. The kernel reports this in
+// /sys/devices/system/cpu/smt, older kernels only list the thread siblings of
+// each cpu. /proc/cpuinfo is used as a last resort, but is unreliable on
+// virtual machines and when cpus are offline
+func (c *Context) SMTActive() (bool, error) {
+	if active, err := utils.ReadString("/sys/devices/system/cpu/smt/active"); err == nil {
+		return active == "1", nil
+	}
+
+	files, err := filepath.Glob("/sys/devices/system/cpu/cpu[0-9]*/topology/thread_siblings_list")
+	if err != nil {
+		return false, err
+	}
+	if len(files) > 0 {
+		for _, file := range files {
+			siblings, err := utils.ReadCPUList(file)
+			if err != nil {
+				return false, err
+			}
+			if len(siblings) > 1 {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+
+	cpuInfo, err := c.CPUInfo()
+	if err != nil {
+		return false, err
+	}
+
+	return cpuInfo.NumCore() != cpuInfo.NumCPU(), nil
+}
+
+// The SMT control state, e.g. forceoff or notsupported, or an empty string
+// if the kernel does not report it
+func SMTControl() string {
+	control, err := utils.ReadString("/sys/devices/system/cpu/smt/control")
+	if err != nil {
+		return ""
+	}
+	return control
+}
+
+func (c *Context) CheckHyperthreading(argument string, common CommonMetadata) (Check, interface{}, error) {
+	m := &HyperthreadingMetadata{}
+	err := ParseMetadata(m, argument, "State")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Without a state, only the control state is verified
+	var check bool
+	switch m.State {
+	case "enabled":
+		check = true
+	case "disabled", "":
+		check = false
+	default:
+		return nil, nil, fmt.Errorf("Unknown target state %s", m.State)
+	}
+
+	if m.Control != "" {
+		var known bool
+		for _, control := range smtControls {
+			known = known || control == m.Control
+		}
+		if !known {
+			return nil, nil, fmt.Errorf("Unknown control state %s, expected one of %s", m.Control, strings.Join(smtControls, ", "))
+		}
+	}
+
+	return func(r *Report) (Status, string) {
+		active, err := c.SMTActive()
+		if err != nil {
+			return Unknown, fmt.Sprintf("Could not determine whether hyperthreading is active: %s", err.Error())
+		}
+
+		control := SMTControl()
+
+		if m.State != "" && active != check {
+			if control != "" {
+				return Critical, fmt.Sprintf("Hyperthreading must be %s, but is %s (control: %s)", m.State, ActiveString(active), control)
+			}
+			return Critical, fmt.Sprintf("Hyperthreading must be %s, but is %s", m.State, ActiveString(active))
+		}
+
+		if m.Control != "" && control != m.Control {
+			if control == "" {
+				return Unknown, "Hyperthreading control state is not reported by the kernel"
+			}
+			return Critical, fmt.Sprintf("Hyperthreading control must be %s, but is %s", m.Control, control)
+		}
+
+		return OK, ""
+	}, m, nil
+}
+
+func ActiveString(active bool) string {
+	if active {
+		return "enabled"
+	}
+	return "disabled"
+}
+
+func (c *Context) CheckCPUSockets(amount string, common CommonMetadata) (Check, interface{}, error) {
+	integer, err := strconv.Atoi(amount)
+	if err != nil {
+		return nil, nil, err
+	}
+	return func(r *Report) (Status, string) {
+		cpuInfo, err := c.CPUInfo()
+		if err != nil {
+			return Unknown, fmt.Sprintf("Could not parse cpuinfo: %s", err.Error())
+		}
+
+		phys := cpuInfo.NumPhysicalCPU()
+
+		if phys != integer {
+			return Critical, fmt.Sprintf("Expected %d CPU sockets, found %d", integer, phys)
+		}
+
+		return OK, ""
+	}, nil, nil
+}
+
+type CPUMetadata struct {
+	Model     string
+	Cores     int
+	Threads   int
+	MinMhz    int
+	Flags     []string
+	Microcode string
+	AllOnline bool
+}
+
+// The maximum frequency of each cpu in MHz, as reported by cpufreq, or the
+// current frequency in /proc/cpuinfo if cpufreq is not available
+func CPUMaxMhz(cpuInfo *linuxproc.CPUInfo) (map[int64]float64, error) {
+	result := map[int64]float64{}
+
+	for _, processor := range cpuInfo.Processors {
+		khz, err := utils.ReadUint(fmt.Sprintf("/sys/devices/system/cpu/cpu%d/cpufreq/cpuinfo_max_freq", processor.Id))
+		if os.IsNotExist(err) {
+			result[processor.Id] = processor.MHz
+		} else if err != nil {
+			return nil, err
+		} else {
+			result[processor.Id] = float64(khz) / 1000
+		}
+	}
+
+	return result, nil
+}
+
+func (c *Context) CheckCPU(argument string, common CommonMetadata) (Check, interface{}, error) {
+	m := &CPUMetadata{
+		AllOnline: true,
+	}
+	err := ParseMetadata(m, argument, "Model")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var model_re *regexp.Regexp
+	if m.Model != "" {
+		model_re, err = regexp.Compile(m.Model)
+		if err != nil {
+			return nil, nil, err
+		}
 	}
 
-	parts := strings.SplitN(m.Device, ":", 2)
-	if len(parts) == 2 {
-		m.Device = parts[0]
-		m.Port, err = strconv.Atoi(parts[1])
+	var microcode uint64
+	if m.Microcode != "" {
+		microcode, err = strconv.ParseUint(m.Microcode, 0, 64)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 	}
 
-	state_re := regexp.MustCompile("4: ACTIVE")
-	speed_re, err := regexp.Compile(fmt.Sprintf("^%d\\s", m.Speed))
-	if err != nil {
-		return nil, err
-	}
+	return func(r *Report) (Status, string) {
+		// Possible cpus include hot-plug slots that are not populated
+		if m.AllOnline {
+			present, err := utils.ReadCPUList("/sys/devices/system/cpu/present")
+			if err != nil {
+				return Unknown, fmt.Sprintf("Could not read present cpus: %s", err.Error())
+			}
+			online, err := utils.ReadCPUList("/sys/devices/system/cpu/online")
+			if err != nil {
+				return Unknown, fmt.Sprintf("Could not read online cpus: %s", err.Error())
+			}
 
-	return func() (Status, string) {
-		file := fmt.Sprintf("/sys/class/infiniband/%s/ports/%d/state", m.Device, m.Port)
-		status, message := AssureContent(file, state_re)
-		if status != OK {
-			return status, message
+			isOnline := map[int]bool{}
+			for _, cpu := range online {
+				isOnline[cpu] = true
+			}
+
+			offline := []string{}
+			for _, cpu := range present {
+				if !isOnline[cpu] {
+					offline = append(offline, strconv.Itoa(cpu))
+				}
+			}
+			if len(offline) > 0 {
+				return Critical, fmt.Sprintf("Not all cpus are online, offline: %s", strings.Join(offline, ","))
+			}
 		}
 
-		file = fmt.Sprintf("/sys/class/infiniband/%s/ports/%d/rate", m.Device, m.Port)
-		status, message = AssureContent(file, speed_re)
-		if status != OK {
-			return status, message
+		cpuInfo, err := c.CPUInfo()
+		if err != nil {
+			return Unknown, fmt.Sprintf("Could not parse cpuinfo: %s", err.Error())
 		}
 
-		message = ""
+		if model_re != nil {
+			for _, processor := range cpuInfo.Processors {
+				if !model_re.MatchString(processor.ModelName) {
+					return Critical, fmt.Sprintf("CPU %d does not match model %s: %s", processor.Id, m.Model, processor.ModelName)
+				}
+			}
+		}
 
-		for _, counter := range infinibandErrorCounters {
-			file = fmt.Sprintf("/sys/class/infiniband/%s/ports/%d/counters/%s", m.Device, m.Port, counter)
+		cores := cpuInfo.NumCore()
+		threads := cpuInfo.NumCPU()
 
-			handle, err := os.Open(file)
-			defer handle.Close()
-			if err != nil {
-				return Unknown, fmt.Sprintf("Could not open file %s: %s", file, err.Error())
+		if m.Cores > 0 && cores != m.Cores {
+			return Critical, fmt.Sprintf("Expected %d physical cores, found %d", m.Cores, cores)
+		}
+
+		if m.Threads > 0 && threads != m.Threads {
+			return Critical, fmt.Sprintf("Expected %d threads, found %d", m.Threads, threads)
+		}
+
+		for _, processor := range cpuInfo.Processors {
+			present := map[string]bool{}
+			for _, flag := range processor.Flags {
+				present[flag] = true
+			}
+
+			missing := []string{}
+			for _, flag := range m.Flags {
+				if !present[flag] {
+					missing = append(missing, flag)
+				}
+			}
+			if len(missing) > 0 {
+				return Critical, fmt.Sprintf("CPU %d is missing flags: %s", processor.Id, strings.Join(missing, ","))
 			}
+		}
 
-			b, err := ioutil.ReadAll(handle)
+		if m.MinMhz > 0 {
+			frequencies, err := CPUMaxMhz(cpuInfo)
 			if err != nil {
-				return Unknown, fmt.Sprintf("Could not read file %s: %s", file, err.Error())
+				return Unknown, fmt.Sprintf("Could not read cpu frequency: %s", err.Error())
+			}
+
+			for _, processor := range cpuInfo.Processors {
+				if mhz := frequencies[processor.Id]; mhz < float64(m.MinMhz) {
+					return Critical, fmt.Sprintf("CPU %d runs at less than %d MHz: %.0f MHz", processor.Id, m.MinMhz, mhz)
+				}
 			}
+		}
 
-			value := strings.TrimSuffix(string(b), "\n")
-			i, err := strconv.ParseUint(value, 10, 64)
+		if m.Microcode != "" {
+			revisions, err := utils.ListCPUInfoValues(cpuinfo_file, "microcode")
 			if err != nil {
-				return Unknown, fmt.Sprintf("Could not read parse %s as integer while reading %s: %s", value, counter, err.Error())
+				return Unknown, fmt.Sprintf("Could not parse cpuinfo: %s", err.Error())
 			}
-			if i >= m.Critical && status != Critical {
-				status = Critical
-				message = fmt.Sprintf("Port counter %s is higher than threshold %d: %d", counter, m.Critical, i)
-			} else if i >= m.Warning && status == OK {
-				status = Warning
-				message = fmt.Sprintf("Port counter %s is higher than threshold %d: %d", counter, m.Warning, i)
+			if len(revisions) == 0 {
+				return Unknown, "No microcode revision found in cpuinfo"
+			}
+
+			for _, revision := range revisions {
+				i, err := strconv.ParseUint(revision, 0, 64)
+				if err != nil {
+					return Unknown, fmt.Sprintf("Could not parse microcode revision %s: %s", revision, err.Error())
+				}
+				if i != microcode {
+					return Warning, fmt.Sprintf("Expected microcode revision %s, found %s", m.Microcode, revision)
+				}
 			}
 		}
 
-		return status, message
-	}, nil
+		return OK, ""
+	}, m, nil
 }
 
-type MountMetadata struct {
-	MountPoint string
-	Device     string
-	FsType     string
-	ReadOnly   bool
-	Remount    bool
+type CPUFreqMetadata struct {
+	Governor  string
+	MinMaxMhz int
+	Throttle  bool
 }
 
-func (c *Context) CheckMount(argument string) (Check, error) {
-	m := &MountMetadata{}
-	err := ParseMetadata(m, argument, "MountPoint")
+var (
+	throttleCounters = []string{"core_throttle_count", "package_throttle_count"}
+)
+
+func (c *Context) CheckCPUFreq(argument string, common CommonMetadata) (Check, interface{}, error) {
+	m := &CPUFreqMetadata{
+		Throttle: true,
+	}
+	err := ParseMetadata(m, argument, "Governor")
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	return func() (Status, string) {
-		if c.mounts == nil {
-			var err error
-			c.mounts, err = linuxproc.ReadMounts("/proc/mounts")
-			if err != nil {
-				return Unknown, fmt.Sprintf("Could not parse mounts: %s", err.Error())
-			}
+	return func(r *Report) (Status, string) {
+		cpus, err := filepath.Glob("/sys/devices/system/cpu/cpu[0-9]*")
+		if err != nil {
+			return Unknown, fmt.Sprintf("Could not list cpus: %s", err.Error())
 		}
 
-		for _, mount := range c.mounts.Mounts {
-			if mount.MountPoint == m.MountPoint && mount.FSType != "autofs" {
-				if m.Device != "" && mount.Device != m.Device {
-					return Critical, fmt.Sprintf("Mount point %s does not match required device %s: %s", m.MountPoint, m.Device, mount.Device)
+		for _, cpu := range cpus {
+			name := filepath.Base(cpu)
+
+			if _, err := os.Stat(fmt.Sprintf("%s/cpufreq", cpu)); os.IsNotExist(err) {
+				// Offline cpus have no cpufreq directory
+				if online, err := utils.ReadString(fmt.Sprintf("%s/online", cpu)); err == nil && online == "0" {
+					continue
 				}
-				if m.FsType != "" && mount.FSType != m.FsType {
-					return Warning, fmt.Sprintf("Mount point %s does not match required fstype %s: %s", m.MountPoint, m.FsType, mount.FSType)
+				if m.Governor != "" || m.MinMaxMhz > 0 {
+					return Unknown, fmt.Sprintf("No cpufreq information for %s, is the cpufreq driver loaded?", name)
 				}
-				if !m.ReadOnly && mount.Options != "rw" && !strings.HasPrefix(mount.Options, "rw,") {
-					return Critical, fmt.Sprintf("Mount point %s is not mounted read-write: %s", m.MountPoint, mount.Options)
+				continue
+			}
+
+			if m.Governor != "" {
+				governor, err := utils.ReadString(fmt.Sprintf("%s/cpufreq/scaling_governor", cpu))
+				if err != nil {
+					return Unknown, fmt.Sprintf("Could not read governor of %s: %s", name, err.Error())
 				}
+				if governor != m.Governor {
+					return Critical, fmt.Sprintf("Expected governor %s for %s, found %s", m.Governor, name, governor)
+				}
+			}
 
-				return OK, ""
+			if m.MinMaxMhz > 0 {
+				khz, err := utils.ReadUint(fmt.Sprintf("%s/cpufreq/scaling_max_freq", cpu))
+				if err != nil {
+					return Unknown, fmt.Sprintf("Could not read maximum frequency of %s: %s", name, err.Error())
+				}
+				if khz < uint64(m.MinMaxMhz)*1000 {
+					return Critical, fmt.Sprintf("Maximum frequency of %s is lower than %d MHz: %d MHz", name, m.MinMaxMhz, khz/1000)
+				}
 			}
 		}
 
-		if m.Remount {
-			cmd := exec.Command("/usr/bin/mount", m.MountPoint)
-			err = cmd.Run()
-			if err != nil {
-				return Critical, fmt.Sprintf("Mount point %s is not mounted, remount failed: %s", m.MountPoint, err)
-			}
-			return Warning, fmt.Sprintf("Mount point %s was not mounted, did remount it", m.MountPoint)
+		if !m.Throttle {
+			return OK, ""
 		}
 
-		return Critical, fmt.Sprintf("Mount point %s is not mounted", m.MountPoint)
-	}, nil
-}
+		// Throttle counters only increase, compare them with the previous run
+		previous, _ := r.PreviousCounters()
+		current := map[string]uint64{}
+		increases := map[string]uint64{}
+		totals := map[string]uint64{}
+		throttled := 0
 
-func (c *Context) CheckFile(file string) (Check, error) {
-	return func() (Status, string) {
-		return AssureExists(file)
-	}, nil
-}
+		for _, cpu := range cpus {
+			var increased bool
 
-func (c *Context) CheckFreeMemory(amount string) (Check, error) {
-	th, err := bytesize.Parse(amount)
-	if err != nil {
-		return nil, err
-	}
-	return func() (Status, string) {
-		if c.memInfo == nil {
-			var err error
-			c.memInfo, err = linuxproc.ReadMemInfo(meminfo_file)
-			if err != nil {
-				return Unknown, fmt.Sprintf("Could not parse meminfo: %s", err.Error())
+			for _, counter := range throttleCounters {
+				i, err := utils.ReadUint(fmt.Sprintf("%s/thermal_throttle/%s", cpu, counter))
+				if err != nil {
+					continue
+				}
+
+				key := fmt.Sprintf("%s/%s", filepath.Base(cpu), counter)
+				current[key] = i
+				totals[counter] += i
+
+				// Counters are reset on reboot
+				if before, ok := previous[key]; ok && i != before {
+					if i > before {
+						increases[counter] += i - before
+					} else {
+						increases[counter] += i
+					}
+					increased = true
+				}
+			}
+
+			if increased {
+				throttled++
 			}
 		}
+		r.StoreCounters(current)
+
+		for _, counter := range throttleCounters {
+			r.AddMetric(Metric{
+				Name:  counter,
+				Value: float64(totals[counter]),
+				Unit:  "c",
+			})
+		}
 
-		if c.memInfo.MemAvailable < uint64(th) {
-			bs := bytesize.ByteSize(c.memInfo.MemAvailable)
-			return Critical, fmt.Sprintf("Available memory is less than threshold %s: %s", th.String(), bs.String())
+		if throttled > 0 {
+			events := []string{}
+			for _, counter := range throttleCounters {
+				if increases[counter] > 0 {
+					events = append(events, fmt.Sprintf("%d %s", increases[counter], strings.TrimSuffix(counter, "_throttle_count")))
+				}
+			}
+			return Warning, fmt.Sprintf("Thermal throttling on %d cpus since the previous run: %s events", throttled, strings.Join(events, ", "))
 		}
 
 		return OK, ""
-	}, nil
+	}, m, nil
 }
 
-func (c *Context) CheckFreeSwap(amount string) (Check, error) {
-	th, err := bytesize.Parse(amount)
+type ThermalMetadata struct {
+	Warning  int
+	Critical int
+	Exclude  string
+}
+
+// A temperature sensor, in millidegrees Celsius
+type Sensor struct {
+	Name        string
+	Temperature int64
+}
+
+// List the temperature sensors of thermal zones and hwmon devices. Sensors
+// that can't be read, e.g. because the device is in a low power state, are skipped
+func ListTemperatureSensors() ([]Sensor, error) {
+	result := []Sensor{}
+
+	zones, err := filepath.Glob("/sys/class/thermal/thermal_zone*")
 	if err != nil {
 		return nil, err
 	}
-	return func() (Status, string) {
-		if c.memInfo == nil {
-			var err error
-			c.memInfo, err = linuxproc.ReadMemInfo(meminfo_file)
-			if err != nil {
-				return Unknown, fmt.Sprintf("Could not parse meminfo: %s", err.Error())
-			}
+	for _, zone := range zones {
+		name := filepath.Base(zone)
+		if zoneType, err := utils.ReadString(fmt.Sprintf("%s/type", zone)); err == nil {
+			name = fmt.Sprintf("%s (%s)", name, zoneType)
 		}
 
-		if c.memInfo.SwapFree < uint64(th) {
-			bs := bytesize.ByteSize(c.memInfo.SwapFree)
-			return Warning, fmt.Sprintf("Free memory is less than threshold %s: %s", th.String(), bs.String())
+		if temperature, err := readTemperature(fmt.Sprintf("%s/temp", zone)); err == nil {
+			result = append(result, Sensor{name, temperature})
 		}
+	}
 
-		return OK, ""
-	}, nil
-}
-
-func (c *Context) CheckFreeTotalMemory(amount string) (Check, error) {
-	th, err := bytesize.Parse(amount)
+	inputs, err := filepath.Glob("/sys/class/hwmon/hwmon*/temp*_input")
 	if err != nil {
 		return nil, err
 	}
-	return func() (Status, string) {
-		if c.memInfo == nil {
-			var err error
-			c.memInfo, err = linuxproc.ReadMemInfo(meminfo_file)
-			if err != nil {
-				return Unknown, fmt.Sprintf("Could not parse meminfo: %s", err.Error())
-			}
+	for _, input := range inputs {
+		hwmon := filepath.Dir(input)
+		sensor := strings.TrimSuffix(filepath.Base(input), "_input")
+		name := fmt.Sprintf("%s/%s", filepath.Base(hwmon), sensor)
+
+		description := []string{}
+		if device, err := utils.ReadString(fmt.Sprintf("%s/name", hwmon)); err == nil {
+			description = append(description, device)
+		}
+		if label, err := utils.ReadString(fmt.Sprintf("%s/%s_label", hwmon, sensor)); err == nil {
+			description = append(description, label)
+		}
+		if len(description) > 0 {
+			name = fmt.Sprintf("%s (%s)", name, strings.Join(description, " "))
 		}
 
-		total := c.memInfo.MemAvailable + c.memInfo.SwapFree
-		if total < uint64(th) {
-			bs := bytesize.ByteSize(total)
-			return Critical, fmt.Sprintf("Available memory is less than threshold %s: %s", th.String(), bs.String())
+		if temperature, err := readTemperature(input); err == nil {
+			result = append(result, Sensor{name, temperature})
 		}
+	}
 
-		return OK, ""
-	}, nil
+	return result, nil
 }
 
-func (c *Context) CheckDimms(argument string) (Check, error) {
-	return func() (Status, string) {
-		channels, err := utils.ListMemoryChannels()
+func readTemperature(file string) (int64, error) {
+	value, err := utils.ReadString(file)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+}
+
+func (c *Context) CheckThermal(argument string, common CommonMetadata) (Check, interface{}, error) {
+	m := &ThermalMetadata{
+		Warning:  85,
+		Critical: 95,
+	}
+	err := ParseMetadata(m, argument, "Warning")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var exclude_re *regexp.Regexp
+	if m.Exclude != "" {
+		exclude_re, err = regexp.Compile(m.Exclude)
 		if err != nil {
-			return Unknown, fmt.Sprintf("Could not parse dimm info: %s", err.Error())
+			return nil, nil, err
 		}
+	}
 
-		var dimmsPerChannel int
-		var dimmSize uint64
-
-		for _, channel := range channels {
-			if dimmsPerChannel == 0 {
-				dimmsPerChannel = len(channel.Dimms)
+	return func(r *Report) (Status, string) {
+		sensors, err := ListTemperatureSensors()
+		if err != nil {
+			return Unknown, fmt.Sprintf("Could not list temperature sensors: %s", err.Error())
+		}
 
-				if dimmsPerChannel == 0 {
-					return Critical, "First memory channel has no dimms"
-				}
-			} else if dimmsPerChannel != len(channel.Dimms) {
-				return Critical, fmt.Sprintf("Number of dimms differ per memory channel: first has %d channels, %s has %d channels", dimmsPerChannel, channel.Name, len(channel.Dimms))
+		var hottest *Sensor
+		for i, sensor := range sensors {
+			if exclude_re != nil && exclude_re.MatchString(sensor.Name) {
+				continue
 			}
-
-			for _, dimm := range channel.Dimms {
-				if dimmSize == 0 {
-					dimmSize = dimm.Size
-
-					if dimmSize == 0 {
-						return Critical, "First dimm has no size"
-					}
-				} else if dimmSize != dimm.Size {
-					return Critical, fmt.Sprintf("Dimm sizes differ: first dimm has size %d, %s/%s has size %d", dimmSize, channel.Name, dimm.Name, dimm.Size)
-				}
+			if hottest == nil || sensor.Temperature > hottest.Temperature {
+				hottest = &sensors[i]
 			}
 		}
 
-		return OK, ""
-	}, nil
-}
-
-func (c *Context) CheckHyperthreading(state string) (Check, error) {
-	var check bool
-	switch state {
-	case "enabled":
-		check = true
-	case "disabled":
-		check = false
-	default:
-		return nil, fmt.Errorf("Unknown target state %s", state)
-	}
-
-	return func() (Status, string) {
-		if c.cpuInfo == nil {
-			var err error
-			c.cpuInfo, err = linuxproc.ReadCPUInfo(cpuinfo_file)
-			if err != nil {
-				return Unknown, fmt.Sprintf("Could not parse cpuinfo: %s", err.Error())
-			}
+		if hottest == nil {
+			return Unknown, "No temperature sensors found"
 		}
 
-		core := c.cpuInfo.NumCore()
-		cpu := c.cpuInfo.NumCPU()
+		celsius := float64(hottest.Temperature) / 1000
 
-		if (core == cpu) == check {
-			return Critical, fmt.Sprintf("Hyperthreading must be %v, but found %v physical cores and %v cores", check, core, cpu)
-		}
-
-		return OK, ""
-	}, nil
-}
+		r.AddMetric(Metric{
+			Name:     "max_temp",
+			Value:    celsius,
+			Warning:  fmt.Sprintf("%d", m.Warning),
+			Critical: fmt.Sprintf("%d", m.Critical),
+		})
 
-func (c *Context) CheckCPUSockets(amount string) (Check, error) {
-	integer, err := strconv.Atoi(amount)
-	if err != nil {
-		return nil, err
-	}
-	return func() (Status, string) {
-		if c.cpuInfo == nil {
-			var err error
-			c.cpuInfo, err = linuxproc.ReadCPUInfo(cpuinfo_file)
-			if err != nil {
-				return Unknown, fmt.Sprintf("Could not parse cpuinfo: %s", err.Error())
-			}
+		if m.Critical > 0 && hottest.Temperature >= int64(m.Critical)*1000 {
+			return Critical, fmt.Sprintf("Temperature of %s is higher than %d°C: %.1f°C", hottest.Name, m.Critical, celsius)
 		}
 
-		phys := c.cpuInfo.NumPhysicalCPU()
-
-		if phys != integer {
-			return Critical, fmt.Sprintf("Expected %d CPU sockets, found %d", integer, phys)
+		if m.Warning > 0 && hottest.Temperature >= int64(m.Warning)*1000 {
+			return Warning, fmt.Sprintf("Temperature of %s is higher than %d°C: %.1f°C", hottest.Name, m.Warning, celsius)
 		}
 
 		return OK, ""
-	}, nil
+	}, m, nil
 }
 
 type DiskUsageMetadata struct {
@@ -356,19 +1524,45 @@ type DiskUsageMetadata struct {
 	MinFree        bytesize.ByteSize
 }
 
-func (c *Context) CheckDiskUsage(argument string) (Check, error) {
+func (c *Context) CheckDiskUsage(argument string, common CommonMetadata) (Check, interface{}, error) {
 	m := &DiskUsageMetadata{}
 	err := ParseMetadata(m, argument, "MountPoint")
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	return func() (Status, string) {
+	return func(r *Report) (Status, string) {
 		stat, err := utils.DiskUsage(m.MountPoint)
 		if err != nil {
 			return Unknown, fmt.Sprintf("Could not retrieve disk usage: %s", err.Error())
 		}
 
+		metric := Metric{
+			Name: "used",
+			Unit: "%",
+			Min:  "0",
+			Max:  "100",
+		}
+		if stat.Blocks > 0 {
+			metric.Value = float64(stat.BlocksUsed) / float64(stat.Blocks) * 100
+		}
+		if m.MaxUsedPercent > 0 {
+			metric.Critical = fmt.Sprintf("%d", m.MaxUsedPercent)
+		}
+		r.AddMetric(metric)
+
+		metric = Metric{
+			Name:  "free",
+			Value: float64(stat.BlocksFree),
+			Unit:  "B",
+			Min:   "0",
+			Max:   fmt.Sprintf("%d", stat.Blocks),
+		}
+		if m.MinFree > 0 {
+			metric.Critical = fmt.Sprintf("%d:", uint64(m.MinFree))
+		}
+		r.AddMetric(metric)
+
 		if m.MaxUsedPercent > 0 && stat.BlocksUsed*100 > stat.Blocks*uint64(m.MaxUsedPercent) {
 			bs := bytesize.ByteSize(stat.BlocksUsed)
 			return Critical, fmt.Sprintf("Disk usage is above %d%%: %s", m.MaxUsedPercent, bs.String())
@@ -380,23 +1574,31 @@ func (c *Context) CheckDiskUsage(argument string) (Check, error) {
 		}
 
 		return OK, ""
-	}, nil
+	}, m, nil
+}
+
+type PortMetadata struct {
+	Port int
 }
 
-func (c *Context) CheckPort(argument string) (Check, error) {
-	port, err := strconv.Atoi(argument)
+func (c *Context) CheckPort(argument string, common CommonMetadata) (Check, interface{}, error) {
+	m := &PortMetadata{}
+	err := ParseMetadata(m, argument, "Port")
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	if m.Port == 0 {
+		return nil, nil, fmt.Errorf("no port given")
 	}
 
-	return func() (Status, string) {
-		conn, err := net.DialTimeout("tcp", fmt.Sprintf(":%d", port), time.Second)
+	return func(r *Report) (Status, string) {
+		conn, err := net.DialTimeout("tcp", fmt.Sprintf(":%d", m.Port), time.Second)
 		if err != nil {
-			return Critical, fmt.Sprintf("Could not connect to port %d: %s", port, err.Error())
+			return Critical, fmt.Sprintf("Could not connect to port %d: %s", m.Port, err.Error())
 		}
 		defer conn.Close()
 		return OK, ""
-	}, nil
+	}, m, nil
 }
 
 type ProcessMetadata struct {
@@ -407,17 +1609,15 @@ type ProcessMetadata struct {
 	Count   uint
 	Start   bool
 	Restart bool
-	Fatal   bool
 }
 
-func (c *Context) CheckProcess(argument string) (Check, error) {
+func (c *Context) CheckProcess(argument string, common CommonMetadata) (Check, interface{}, error) {
 	m := &ProcessMetadata{
-		Fatal: true,
 		Count: 1,
 	}
 	err := ParseMetadata(m, argument, "Service")
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	if m.Daemon == "" {
@@ -426,18 +1626,15 @@ func (c *Context) CheckProcess(argument string) (Check, error) {
 
 	m.Cmdline = strings.ReplaceAll(m.Cmdline, "+", " ")
 
-	return func() (Status, string) {
-		if c.psInfo == nil {
-			var err error
-			c.psInfo, err = utils.ListProcesses()
-			if err != nil {
-				return Unknown, fmt.Sprintf("Could not parse process info: %s", err.Error())
-			}
+	return func(r *Report) (Status, string) {
+		psInfo, err := c.Processes()
+		if err != nil {
+			return Unknown, fmt.Sprintf("Could not parse process info: %s", err.Error())
 		}
 
 		var seen uint
 
-		for _, pStatus := range c.psInfo {
+		for _, pStatus := range psInfo {
 			if pStatus.Name == m.Daemon {
 				if m.Cmdline != "" {
 					pCmdline, err := linuxproc.ReadProcessCmdline(fmt.Sprintf("/proc/%d/cmdline", pStatus.Pid))
@@ -463,13 +1660,14 @@ func (c *Context) CheckProcess(argument string) (Check, error) {
 				if m.User != "" {
 					user, err := user.Lookup(m.User)
 					if err != nil {
-						return Unknown.NonFatalUnless(m.Fatal), fmt.Sprintf("Could not lookup %s: %s", m.User, err.Error())
+						return Unknown, fmt.Sprintf("Could not lookup %s: %s", m.User, err.Error())
 					}
 					uid, err := strconv.ParseUint(user.Uid, 10, 64)
 					if err != nil {
-						return Unknown.NonFatalUnless(m.Fatal), fmt.Sprintf("Could not parse uid %s: %s", user.Uid, err.Error())
+						return Unknown, fmt.Sprintf("Could not parse uid %s: %s", user.Uid, err.Error())
 					}
 					if pStatus.EffectiveUid != uid {
+						r.KeepFatal()
 						return Critical, fmt.Sprintf("Process %s is not running under user %d (%s), but %d", m.Service, uid, m.User, pStatus.EffectiveUid)
 					}
 				}
@@ -504,13 +1702,31 @@ func (c *Context) CheckProcess(argument string) (Check, error) {
 			cmd := exec.Command("/usr/bin/systemctl", action, m.Service)
 			err := cmd.Run()
 			if err != nil {
-				return Critical.NonFatalUnless(m.Fatal), fmt.Sprintf("Process %s not found, and could not start: %s", m.Service, err.Error())
+				return Critical, fmt.Sprintf("Process %s not found, and could not start: %s", m.Service, err.Error())
 			}
+			r.KeepFatal()
 			return Critical, fmt.Sprintf("Process %s not found, %sed it successfully", m.Service, action)
 		}
 
-		return Critical.NonFatalUnless(m.Fatal), fmt.Sprintf("Process %s not found", m.Service)
-	}, nil
+		return Critical, fmt.Sprintf("Process %s not found", m.Service)
+	}, m, nil
+}
+
+type UserMetadata struct {
+	User string
+}
+
+// Parse the argument of the user check, which is implemented with or without cgo
+func ParseUserMetadata(argument string) (*UserMetadata, error) {
+	m := &UserMetadata{}
+	err := ParseMetadata(m, argument, "User")
+	if err != nil {
+		return nil, err
+	}
+	if m.User == "" {
+		return nil, fmt.Errorf("no user given")
+	}
+	return m, nil
 }
 
 type UnauthorizedMetadata struct {
@@ -518,14 +1734,129 @@ type UnauthorizedMetadata struct {
 	MaxSystemUid uint64
 }
 
-func (c *Context) CheckCommand(argument string) (Check, error) {
-	return func() (Status, string) {
-		cmd := exec.Command("/bin/sh", "-c", argument)
-		err := cmd.Run()
+type CommandMetadata struct {
+	Command      string
+	ExpectOutput string
+	Nagios       bool
+}
+
+// The command is killed when the timeout of the common metadata expires
+func (c *Context) CheckCommand(argument string, common CommonMetadata) (Check, interface{}, error) {
+	m := &CommandMetadata{}
+	err := ParseTrailingMetadata(m, argument, "Command")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var expect_re *regexp.Regexp
+	if m.ExpectOutput != "" {
+		expect_re, err = regexp.Compile(m.ExpectOutput)
 		if err != nil {
-			return Critical, fmt.Sprintf("Command %s could not run successfully: %s", argument, err.Error())
+			return nil, nil, err
 		}
+	}
 
-		return OK, ""
-	}, nil
+	return func(r *Report) (Status, string) {
+		output, rc, err := utils.RunCommand("/bin/sh", []string{"-c", m.Command}, common.Timeout)
+		if err == utils.ErrTimeout {
+			return Critical, fmt.Sprintf("Command %s timed out after %s", m.Command, common.Timeout.String())
+		} else if err != nil {
+			return Critical, fmt.Sprintf("Command %s could not run successfully: %s", m.Command, err.Error())
+		}
+
+		var status Status
+		var message string
+
+		if m.Nagios {
+			var metrics []Metric
+			status = NagiosStatus(rc)
+			message, metrics, err = ParseNagiosOutput(string(output))
+			if err != nil {
+				// The exit code and message are still valid, only the metrics are lost
+				message = fmt.Sprintf("%s (ignoring performance data: %s)", message, err.Error())
+			}
+			for _, metric := range metrics {
+				r.AddMetric(metric)
+			}
+		} else if rc != 0 {
+			return Critical, fmt.Sprintf("Command %s could not run successfully: exit status %d", m.Command, rc)
+		}
+
+		if status == OK && expect_re != nil && !expect_re.Match(output) {
+			s := strings.TrimSuffix(string(output), "\n")
+			return Critical, fmt.Sprintf("Output of command %s does not match %s: %s", m.Command, expect_re.String(), s)
+		}
+
+		return status, message
+	}, m, nil
+}
+
+type PluginMetadata struct {
+	Plugin string
+}
+
+// The plugin is killed when the timeout of the common metadata expires, by
+// default after a minute
+func (c *Context) CheckPlugin(argument string, common CommonMetadata) (Check, interface{}, error) {
+	m := &PluginMetadata{}
+	err := ParseTrailingMetadata(m, argument, "Plugin")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Arguments are not passed through a shell, but can be quoted
+	args, err := SplitWords(m.Plugin)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(args) == 0 {
+		return nil, nil, fmt.Errorf("no plugin given")
+	}
+
+	timeout := common.Timeout
+	if timeout == 0 {
+		timeout = 60 * time.Second
+	}
+
+	return func(r *Report) (Status, string) {
+		// Plugins may be installed after the config is written, e.g. by configuration management
+		path, err := FindPlugin(args[0], *fPluginPath)
+		if err != nil {
+			return Unknown, fmt.Sprintf("Could not find plugin: %s", err.Error())
+		}
+
+		output, rc, err := utils.RunCommand(path, args[1:], timeout)
+		if err == utils.ErrTimeout {
+			return Critical, fmt.Sprintf("Plugin %s timed out after %s", args[0], timeout.String())
+		} else if err != nil {
+			return Unknown, fmt.Sprintf("Plugin %s could not run: %s", args[0], err.Error())
+		}
+
+		message, metrics, err := ParseNagiosOutput(string(output))
+		if err != nil {
+			// The exit code and message are still valid, only the metrics are lost
+			message = fmt.Sprintf("%s (ignoring performance data: %s)", message, err.Error())
+		}
+		for _, metric := range metrics {
+			r.AddMetric(metric)
+		}
+
+		return NagiosStatus(rc), message
+	}, m, nil
+}
+
+// Locate a plugin in the given directories, unless an absolute path is given
+func FindPlugin(plugin string, directories []string) (string, error) {
+	if filepath.IsAbs(plugin) {
+		return plugin, nil
+	}
+
+	for _, directory := range directories {
+		path := filepath.Join(directory, plugin)
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path, nil
+		}
+	}
+
+	return "", fmt.Errorf("plugin %s not found in %s", plugin, strings.Join(directories, ", "))
 }
@@ -6,49 +6,41 @@ import (
 	"fmt"
 	"os/user"
 	"strconv"
-
-	"gitea.icts.kuleuven.be/ceif-lnx/go-nhc/utils"
 )
 
-func (c *Context) CheckUnauthorized(argument string) (Check, error) {
+func (c *Context) CheckUnauthorized(argument string, common CommonMetadata) (Check, interface{}, error) {
 	m := &UnauthorizedMetadata{}
 	err := ParseMetadata(m, argument, "Scheduler")
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	switch m.Scheduler {
 	case "pbs":
 	default:
-		return nil, fmt.Errorf("Unknown job scheduler %s", m.Scheduler)
+		return nil, nil, fmt.Errorf("Unknown job scheduler %s", m.Scheduler)
 	}
 	if m.MaxSystemUid == 0 {
 		m.MaxSystemUid = 1000
 	}
 
-	return func() (Status, string) {
-		if c.psInfo == nil {
-			var err error
-			c.psInfo, err = utils.ListProcesses()
-			if err != nil {
-				return Unknown, fmt.Sprintf("Could not parse process info: %s", err.Error())
-			}
+	return func(r *Report) (Status, string) {
+		psInfo, err := c.Processes()
+		if err != nil {
+			return Unknown, fmt.Sprintf("Could not parse process info: %s", err.Error())
 		}
-		if c.jobInfo == nil {
-			var err error
-			c.jobInfo, err = utils.ListPBSJobs()
-			if err != nil {
-				return Unknown, fmt.Sprintf("Could not parse job info for %s: %s", argument, err.Error())
-			}
+		jobInfo, err := c.Jobs()
+		if err != nil {
+			return Unknown, fmt.Sprintf("Could not parse job info for %s: %s", argument, err.Error())
 		}
 
 	OUTER:
-		for _, pStatus := range c.psInfo {
+		for _, pStatus := range psInfo {
 			if pStatus.RealUid < m.MaxSystemUid {
 				continue OUTER
 			}
 
 			// Check whether the uid corresponds with a job uid - usually the case
-			for _, job := range c.jobInfo {
+			for _, job := range jobInfo {
 				if job.Uid == pStatus.RealUid {
 					continue OUTER
 				}
@@ -71,7 +63,7 @@ func (c *Context) CheckUnauthorized(argument string) (Check, error) {
 				}
 				groupInts = append(groupInts, groupInt)
 			}
-			for _, job := range c.jobInfo {
+			for _, job := range jobInfo {
 				for _, gid := range groupInts {
 					if job.Gid == gid {
 						continue OUTER
@@ -82,15 +74,20 @@ func (c *Context) CheckUnauthorized(argument string) (Check, error) {
 		}
 
 		return OK, ""
-	}, nil
+	}, m, nil
 }
 
-func (c *Context) CheckUser(username string) (Check, error) {
-	return func() (Status, string) {
-		_, err := user.Lookup(username)
+func (c *Context) CheckUser(argument string, common CommonMetadata) (Check, interface{}, error) {
+	m, err := ParseUserMetadata(argument)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return func(r *Report) (Status, string) {
+		_, err := user.Lookup(m.User)
 		if err != nil {
-			return Critical, fmt.Sprintf("User information for %s could not be retrieved: %s", username, err.Error())
+			return Critical, fmt.Sprintf("User information for %s could not be retrieved: %s", m.User, err.Error())
 		}
 		return OK, ""
-	}, nil
+	}, m, nil
 }
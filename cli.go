@@ -6,6 +6,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/iancoleman/strcase"
 	"github.com/inhies/go-bytesize"
@@ -15,65 +16,165 @@ import (
 var (
 	fApp = kingpin.New(programName, "Node Health Check")
 
-	fSensuAddr  = fApp.Flag("sensu-addr", "Address of sensu to send check results to").Default("127.0.0.1:3030").String()
-	fStatsdAddr = fApp.Flag("statds-addr", "Address of statsd to send metrics to").Default("127.0.0.1:8125").String()
+	fSensuAddr       = fApp.Flag("sensu-addr", "Address of sensu to send check results to").Default("127.0.0.1:3030").String()
+	fSensuBackend    = fApp.Flag("sensu-backend", "Sensu agent to send check results to: classic (client socket) or go (agent events API)").Default("classic").Enum("classic", "go")
+	fSensuURL        = fApp.Flag("sensu-url", "URL of the events API of the sensu go agent").Default("http://127.0.0.1:3031/events").String()
+	fSensuInterval   = fApp.Flag("sensu-interval", "Interval at which go-nhc is run, reported to sensu go").Default("5m").Duration()
+	fSensuTTL        = fApp.Flag("sensu-ttl", "Time after which sensu go considers a check result stale, should exceed the interval").Default("15m").Duration()
+	fSensuHandlers   = fApp.Flag("sensu-handler", "Handlers for check results sent to sensu go").Strings()
+	fSensuLabels     = fApp.Flag("sensu-label", "Labels to add to check results sent to sensu go, format: KEY=VALUE").StringMap()
+	fSendRetries     = fApp.Flag("send-retries", "Number of times to retry sending results").Default("0").Int()
+	fSendBackoff     = fApp.Flag("send-backoff", "Time to wait before retrying to send results, doubled after each attempt").Default("1s").Duration()
+	fSpoolDir        = fApp.Flag("spool-dir", "Directory to spool results that could not be sent, to send them on the next run, e.g. /var/spool/go-nhc").Default("").String()
+	fSpoolMax        = fApp.Flag("spool-max", "Maximum number of results to keep in the spool, the oldest results are dropped first, 0 for no limit").Default("1000").Int()
+	fSpoolMaxAge     = fApp.Flag("spool-max-age", "Time after which spooled results are dropped, 0 to keep them until they are sent").Default("24h").Duration()
+	fWebhookURL      = fApp.Flag("webhook-url", "URL to post a summary of the results to").String()
+	fWebhookHeaders  = fApp.Flag("webhook-header", "Headers to add to webhook requests, format: NAME=VALUE").StringMap()
+	fWebhookTemplate = fApp.Flag("webhook-template", "Go text/template file to render the webhook request body, instead of the default JSON document").String()
+	fWebhookCert     = fApp.Flag("webhook-cert", "TLS client certificate for webhook requests").String()
+	fWebhookKey      = fApp.Flag("webhook-key", "TLS client key for webhook requests").String()
+	fWebhookCA       = fApp.Flag("webhook-ca", "CA certificates to verify the webhook server").String()
+	fWebhookOn       = fApp.Flag("webhook-on", "When to call the webhook: on every run, or only when the status of a check changed").Default("always").Enum("always", "change")
+	fLog             = fApp.Flag("log", "Log check results that are not OK to syslog or journald").Default("none").Enum("none", "syslog", "journald")
+	fLogAll          = fApp.Flag("log-all", "Log the results of all checks, including checks that passed").Bool()
+	fStateFile       = fApp.Flag("state-file", "File to keep the state of checks between runs, e.g. /var/lib/go-nhc/state.json").Default("").String()
+	fStatsdAddr      = fApp.Flag("statds-addr", "Address of statsd to send metrics to").Default("127.0.0.1:8125").String()
 
 	fCheckInterfaces      = fApp.Flag("interface", "Check the listed network interfaces").Default("").Strings()
-	fCheckInfinibands     = fApp.Flag("infiniband", "Check the listed infiniband ports, format: '<DEV> port=<PORT> speed=<NUM>'").Default("").Strings()
+	fCheckInfinibands     = fApp.Flag("infiniband", "Check the listed infiniband ports, format: '<DEV> port=<PORT> speed=<NUM> [warning=<NUM>] [critical=<NUM>] [counters=<NAME>[:<WARN>[:<CRIT>]],...]'").Default("").Strings()
 	fCheckMounts          = fApp.Flag("mount", "Check whether the listed mounts exist, format: '<MOUNTPOINT> [device=<DEV>] [fs_type=<TYPE>] [remount=<BOOL>]'").Default("").Strings()
 	fCheckDiskUsages      = fApp.Flag("disk-usage", "Check whether the disk usage is below the threshold, format: 'mountpoint [max_used_percent=<INT>] [min_free=<SIZE>]'").Default("").Strings()
-	fCheckFiles           = fApp.Flag("file", "Check whether the listed files exist").Default("").Strings()
+	fCheckFiles           = fApp.Flag("file", "Check whether the listed files exist, format: '<FILE> [owner=<USER>] [group=<GROUP>] [mode=<OCTAL>] [type=<TYPE>] [min_size=<SIZE>] [max_size=<SIZE>] [max_age=<DURATION>] [contains=<REGEX>] [sha256=<HASH>]'").Default("").Strings()
+	fCheckSymlinks        = fApp.Flag("symlink", "Check whether the listed symlinks exist, format: '<PATH> [target=<TARGET>] [resolves=<BOOL>]'").Default("").Strings()
+	fCheckDirectories     = fApp.Flag("directory", "Check whether the listed directories exist, format: '<PATH> [owner=<USER>] [group=<GROUP>] [mode=<OCTAL>] [min_entries=<INT>]'").Default("").Strings()
 	fCheckUsers           = fApp.Flag("user", "Check whether the listed users exist").Default("").Strings()
 	fCheckProcesses       = fApp.Flag("process", "Check whether the given service is running, format: '<SERVICE> [daemon=<PROCESS_NAME>] [user=<USER>]'").Default("").Strings()
 	fCheckPorts           = fApp.Flag("port", "Check whether a service is listening at the given port").Default("").Strings()
-	fCheckCommands        = fApp.Flag("command", "Check whether the given command returns successfully").Default("").Strings()
+	fCheckCommands        = fApp.Flag("command", "Check whether the given command returns successfully, format: '<COMMAND> [timeout=<DURATION>] [expect_output=<REGEX>] [nagios=<BOOL>]'").Default("").Strings()
+	fCheckPlugins         = fApp.Flag("plugin", "Run the given nagios plugin, format: '<PLUGIN> [<ARGS>...] [timeout=<DURATION>]'").Default("").Strings()
 	fCheckFreeMemory      = fApp.Flag("memory", "Check whether the given amount of physical memory is free").Default("").String()
 	fCheckFreeSwap        = fApp.Flag("swap", "Check whether the given amount of swap memory is free").Default("").String()
 	fCheckFreeTotalMemory = fApp.Flag("total-memory", "Check whether the given amount of total memory is free").Default("").String()
-	fCheckDimms           = fApp.Flag("dimms", "Check that each memory channel has the same number of dimms, and that the dimm size is consistent").Default("").Enum("consistent", "")
-	fCheckHyperthreading  = fApp.Flag("hyperthreading", "Check whether hyperthreading is enabled or disabled").Default("").Enum("enabled", "disabled", "")
+	fCheckMemoryTotal     = fApp.Flag("memory-total", "Check whether the installed memory is within range, format: '[min=<SIZE>] [max=<SIZE>]'").Default("").String()
+	fCheckNuma            = fApp.Flag("numa", "Check the number of numa nodes, and whether memory is balanced across them, format: '[nodes=<INT>] [tolerance=<PERCENT>]'").Default("").String()
+	fCheckDimms           = fApp.Flag("dimms", "Check that each memory channel has the same number of dimms, and that the dimm size is consistent, format: '[consistent] [count=<INT>] [size=<SIZE>] [per_channel=<INT>]'").Default("").String()
+	fCheckEcc             = fApp.Flag("ecc", "Check the EDAC error counters of each dimm, format: '[<CE_RATE_WARNING>] [ce_rate_critical=<NUM>] [ce_warning=<NUM>] [ce_critical=<NUM>] [ce_rate_window=<DURATION>]', rates are per hour").Default("").String()
+	fCheckHyperthreading  = fApp.Flag("hyperthreading", "Check whether hyperthreading is enabled or disabled, format: '[enabled|disabled] [control=<on|off|forceoff|notsupported|notimplemented>]'").Default("").String()
 	fCheckCPUSockets      = fApp.Flag("cpu-sockets", "Check whether the given amount of cpu sockets is present").Default("").String()
+	fCheckCPU             = fApp.Flag("cpu", "Check the cpu model and topology, format: '[<MODEL_REGEX>] [cores=<INT>] [threads=<INT>] [min_mhz=<INT>] [flags=<FLAG>,...] [microcode=<REVISION>] [all_online=<BOOL>]'").Default("").String()
+	fCheckCPUFreq         = fApp.Flag("cpufreq", "Check the cpu frequency scaling, and whether cpus were throttled since the previous run, format: '[<GOVERNOR>] [min_max_mhz=<INT>] [throttle=<BOOL>]'").Default("").String()
+	fCheckThermal         = fApp.Flag("thermal", "Check the temperature sensors, in degrees Celsius, format: '[<WARNING>] [critical=<INT>] [exclude=<REGEX>]'").Default("").String()
+	fCheckInventory       = fApp.Flag("inventory", "Check whether the hardware inventory matches the baseline, which is recorded if missing, format: '<BASELINE>'").Default("").String()
 	fCheckUnauthorized    = fApp.Flag("unauthorized", "Check whether unauthorized jobs are running, not governed by the specified job scheduler").Default("").String()
 
+	fPluginPath = fApp.Flag("plugin-path", "Directories to look for nagios plugins").Default("/usr/lib64/nagios/plugins", "/etc/sensu/plugins").Strings()
+
+	fCheckCommand  = fApp.Command("check", "Run the checks given as flags").Default()
+	fStatusCommand = fApp.Command("status", "Show how long each check has been failing, based on the state file")
+
+	fValidateCommand = fApp.Command("validate", "Parse the checks in a config file without running them")
+	fValidateConfig  = fValidateCommand.Flag("config", "Config file with one check flag and its argument per line").Default("/etc/nhc.conf").String()
+
+	fExplainCommand = fApp.Command("explain", "Show the resolved options of a check")
+	fExplainConfig  = fExplainCommand.Flag("config", "Config file with one check flag and its argument per line").Default("/etc/nhc.conf").String()
+	fExplainLabel   = fExplainCommand.Arg("label", "Label of the check").Required().String()
+
+	fGenerateCommand = fApp.Command("generate", "Print a config file with checks for the current state of the node")
+
+	fRecordInventoryCommand = fApp.Command("record-inventory", "Record the hardware inventory of the node as the baseline of the inventory check")
+	fRecordInventoryFile    = fRecordInventoryCommand.Arg("baseline", "Baseline file of the inventory check").Required().String()
+
 	fVerbose = fApp.Flag("verbose", "Verbose mode - show ignored checks and print summarizing message").Short('v').Bool()
 	fList    = fApp.Flag("list", "List all checks that passed").Short('l').Bool()
 	fAll     = fApp.Flag("all", "Run all checks, do not stop on first fatal check").Short('a').Bool()
 	fNoSend  = fApp.Flag("do-not-send", "Do not send check results to sensu agent or statsd server").Short('s').Bool()
+	fOnly    = fApp.Flag("only", "Only run checks with the given tag or type, or with a label matching the given glob").Strings()
+	fSkip    = fApp.Flag("skip", "Do not run checks with the given tag or type, or with a label matching the given glob").Strings()
+	fOutput  = fApp.Flag("output", "Output format: text, or nagios for a single summary line with performance data and long output").Default("text").Enum("text", "nagios")
 )
 
 var (
 	metadataMapRegex = regexp.MustCompile("[=]")
+	metadataKeyRegex = regexp.MustCompile("^[a-z][a-z0-9_]*$")
 	stringType       = reflect.TypeOf("")
 	intType          = reflect.TypeOf(0)
 	uintType         = reflect.TypeOf(uint(0))
 	uint64Type       = reflect.TypeOf(uint64(0))
 	boolType         = reflect.TypeOf(false)
 	byteSizeType     = reflect.TypeOf(bytesize.ByteSize(0))
+	durationType     = reflect.TypeOf(time.Duration(0))
+	stringSliceType  = reflect.TypeOf([]string{})
 )
 
+// Parse metadata given as trailing KEY=VALUE pairs, all preceding words are
+// assigned to default_key. Used for arguments that contain spaces, e.g. commands.
+// Only KEY=VALUE pairs with a known key are considered metadata.
+func ParseTrailingMetadata(meta interface{}, argument string, default_key string) error {
+	arguments := strings.Split(argument, " ")
+	target := reflect.ValueOf(meta).Elem()
+
+	index := len(arguments)
+	for index > 1 {
+		parts := metadataMapRegex.Split(arguments[index-1], 2)
+		if len(parts) != 2 || !MetadataField(target, parts[0]).IsValid() {
+			break
+		}
+		index--
+	}
+
+	field := target.FieldByName(default_key)
+	if !field.IsValid() || field.Type() != stringType {
+		return fmt.Errorf("Cannot assign to key '%s'", default_key)
+	}
+	field.SetString(strings.Join(arguments[:index], " "))
+
+	if index == len(arguments) {
+		return nil
+	}
+
+	return ParseMetadata(meta, strings.Join(arguments[index:], " "), "")
+}
+
+// Find the field of a metadata struct for a key, which must be the exact
+// snake_case name of the field, e.g. fs_type for FsType
+func MetadataField(target reflect.Value, key string) reflect.Value {
+	if !metadataKeyRegex.MatchString(key) {
+		return reflect.Value{}
+	}
+	return target.FieldByName(strcase.ToCamel(key))
+}
+
 func ParseMetadata(meta interface{}, argument string, default_key string) error {
+	// e.g. only common metadata was given, keep the defaults
+	if argument == "" {
+		return nil
+	}
+
 	arguments := strings.Split(argument, " ")
 	target := reflect.ValueOf(meta).Elem()
 
 	for index, value := range arguments {
 		parts := metadataMapRegex.Split(value, 2)
 
-		var key string
+		var field reflect.Value
 		var str string
 
 		if index == 0 && len(parts) == 1 && default_key != "" {
-			key = default_key
+			field = target.FieldByName(default_key)
 			str = parts[0]
 		} else if len(parts) != 2 {
 			return fmt.Errorf("expected KEY=VALUE got '%s'", value)
 		} else {
-			key = parts[0]
+			field = MetadataField(target, parts[0])
 			str = parts[1]
+
+			if !field.IsValid() {
+				return fmt.Errorf("got unallowed key '%s'%s", parts[0], DidYouMean(parts[0], MetadataKeys(meta, CommonMetadata{})))
+			}
 		}
 
-		field := target.FieldByName(strcase.ToCamel(key))
 		if !field.IsValid() {
-			return fmt.Errorf("got unallowed key '%s'", key)
+			return fmt.Errorf("Cannot assign to key '%s'", default_key)
 		}
 
 		var val reflect.Value
@@ -120,6 +221,22 @@ func ParseMetadata(meta interface{}, argument string, default_key string) error
 			}
 			val = reflect.ValueOf(v)
 
+		case stringSliceType:
+			v := []string{}
+			for _, item := range strings.Split(str, ",") {
+				if item != "" {
+					v = append(v, item)
+				}
+			}
+			val = reflect.ValueOf(v)
+
+		case durationType:
+			v, err := time.ParseDuration(str)
+			if err != nil {
+				return err
+			}
+			val = reflect.ValueOf(v)
+
 		default:
 			return fmt.Errorf("Cannot handle type '%s'", field.Type().String())
 		}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Metadata accepted by every check. These keys are stripped from the argument
+// by Register before it is passed to the CheckFactory, see ParseCommonMetadata
+type CommonMetadata struct {
+	Label                string
+	Timeout              time.Duration
+	Interval             time.Duration
+	Tags                 []string
+	DependsOn            []string
+	FailuresBeforeAlert  int
+	SuccessesBeforeClear int
+	Severity             string
+	Fatal                bool
+	Ignore               bool
+}
+
+func NewCommonMetadata() CommonMetadata {
+	return CommonMetadata{
+		FailuresBeforeAlert:  1,
+		SuccessesBeforeClear: 1,
+		Fatal:                true,
+	}
+}
+
+// Parse the keys of CommonMetadata from an argument, and return the remainder
+// of the argument to be passed to the check itself. If trailing is set, e.g.
+// for commands, only the KEY=VALUE pairs at the end of the argument are
+// considered, so that the arguments of the command itself are left alone
+func ParseCommonMetadata(meta *CommonMetadata, argument string, trailing bool) (string, error) {
+	target := reflect.ValueOf(meta).Elem()
+	arguments := strings.Split(argument, " ")
+	remainder := []string{}
+
+	first := 0
+	if trailing {
+		first = len(arguments)
+		for first > 1 {
+			parts := metadataMapRegex.Split(arguments[first-1], 2)
+			if len(parts) != 2 || !metadataKeyRegex.MatchString(parts[0]) {
+				break
+			}
+			first--
+		}
+		remainder = append(remainder, arguments[:first]...)
+	}
+
+	for _, value := range arguments[first:] {
+		parts := metadataMapRegex.Split(value, 2)
+		if len(parts) != 2 || !MetadataField(target, parts[0]).IsValid() {
+			remainder = append(remainder, value)
+			continue
+		}
+
+		err := ParseMetadata(meta, value, "")
+		if err != nil {
+			return "", err
+		}
+	}
+
+	switch meta.Severity {
+	case "", "warning", "critical":
+	default:
+		return "", fmt.Errorf("Unknown severity %s", meta.Severity)
+	}
+
+	return strings.Join(remainder, " "), nil
+}
+
+// Wrap a check to apply the timeout, severity, fatal and ignore keys
+func (m CommonMetadata) Wrap(check Check) Check {
+	return func(r *Report) (Status, string) {
+		status, message := m.run(check, r)
+
+		if m.Ignore {
+			return Ignore, message
+		}
+
+		if status != OK && status != Ignore {
+			switch m.Severity {
+			case "warning":
+				status = Warning
+			case "critical":
+				status = Critical
+			}
+		}
+
+		if !m.Fatal && !r.Fatal {
+			status = status.NonFatal()
+		}
+
+		return status, message
+	}
+}
+
+// Run a check, giving up after the timeout. A check that times out is left
+// running in the background, e.g. when it is blocked on a hanging file system.
+// It reports to a report of its own, which is only used if it finishes in time
+func (m CommonMetadata) run(check Check, r *Report) (Status, string) {
+	if m.Timeout == 0 {
+		return check(r)
+	}
+
+	type result struct {
+		status  Status
+		message string
+		report  *Report
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		report := r.fork()
+		status, message := check(report)
+		done <- result{status, message, report}
+	}()
+
+	timer := time.NewTimer(m.Timeout)
+	defer timer.Stop()
+
+	select {
+	case res := <-done:
+		r.Metrics = res.report.Metrics
+		r.Counters = res.report.Counters
+		r.Fatal = res.report.Fatal
+		return res.status, res.message
+	case <-timer.C:
+		return Unknown, fmt.Sprintf("Check timed out after %s", m.Timeout.String())
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseCommonMetadata(t *testing.T) {
+	tests := []struct {
+		argument  string
+		trailing  bool
+		remainder string
+		expected  func(m *CommonMetadata)
+	}{
+		{
+			argument:  "/scratch fs_type=nfs label=scratch timeout=5s",
+			remainder: "/scratch fs_type=nfs",
+			expected: func(m *CommonMetadata) {
+				m.Label = "scratch"
+				m.Timeout = 5 * time.Second
+			},
+		},
+		{
+			argument:  "/ tags=a,b depends_on=c fatal=no severity=warning",
+			remainder: "/",
+			expected: func(m *CommonMetadata) {
+				m.Tags = []string{"a", "b"}
+				m.DependsOn = []string{"c"}
+				m.Fatal = false
+				m.Severity = "warning"
+			},
+		},
+		{
+			// Only exact snake_case keys are common metadata
+			argument:  "/ Label=x --timeout=10",
+			remainder: "/ Label=x --timeout=10",
+		},
+		{
+			// Only common keys, the check gets its defaults
+			argument:  "tags=x",
+			remainder: "",
+			expected: func(m *CommonMetadata) {
+				m.Tags = []string{"x"}
+			},
+		},
+		{
+			argument:  "grep -q label=foo /etc/x",
+			trailing:  true,
+			remainder: "grep -q label=foo /etc/x",
+		},
+		{
+			argument:  "grep -q label=foo /etc/x timeout=5s label=bar",
+			trailing:  true,
+			remainder: "grep -q label=foo /etc/x",
+			expected: func(m *CommonMetadata) {
+				m.Label = "bar"
+				m.Timeout = 5 * time.Second
+			},
+		},
+		{
+			// Trailing metadata of the command itself is left alone
+			argument:  "/usr/lib/nagios/check_ntp -H host warning=1 label=ntp",
+			trailing:  true,
+			remainder: "/usr/lib/nagios/check_ntp -H host warning=1",
+			expected: func(m *CommonMetadata) {
+				m.Label = "ntp"
+			},
+		},
+		{
+			// The command itself is never metadata
+			argument:  "label=x",
+			trailing:  true,
+			remainder: "label=x",
+		},
+	}
+
+	for _, test := range tests {
+		expected := NewCommonMetadata()
+		if test.expected != nil {
+			test.expected(&expected)
+		}
+
+		m := NewCommonMetadata()
+		remainder, err := ParseCommonMetadata(&m, test.argument, test.trailing)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.argument, err.Error())
+			continue
+		}
+		if remainder != test.remainder {
+			t.Errorf("%s: expected remainder '%s', got '%s'", test.argument, test.remainder, remainder)
+		}
+		if !reflect.DeepEqual(m, expected) {
+			t.Errorf("%s: expected %+v, got %+v", test.argument, expected, m)
+		}
+	}
+}
+
+func TestParseCommonMetadataErrors(t *testing.T) {
+	for _, argument := range []string{
+		"/ severity=fatal",
+		"/ timeout=soon",
+		"/ failures_before_alert=many",
+	} {
+		m := NewCommonMetadata()
+		if _, err := ParseCommonMetadata(&m, argument, false); err == nil {
+			t.Errorf("%s: expected an error", argument)
+		}
+	}
+}
+
+func TestParseMetadataEmpty(t *testing.T) {
+	m := &struct {
+		Device    string
+		Tolerance int
+	}{
+		Tolerance: 5,
+	}
+	if err := ParseMetadata(m, "", "Device"); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if m.Device != "" || m.Tolerance != 5 {
+		t.Errorf("expected the defaults to be kept, got %+v", m)
+	}
+}
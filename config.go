@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// A line of the config file, in the format read by /usr/sbin/nhc:
+// the name of a flag followed by its (quoted) argument
+type ConfigLine struct {
+	File     string
+	Line     int
+	Flag     string
+	Argument string
+}
+
+func (l ConfigLine) String() string {
+	return fmt.Sprintf("%s:%d", l.File, l.Line)
+}
+
+func ReadConfig(file string) ([]ConfigLine, error) {
+	handle, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer handle.Close()
+
+	result := []ConfigLine{}
+	scanner := bufio.NewScanner(handle)
+	number := 0
+
+	for scanner.Scan() {
+		number++
+
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		words, err := SplitWords(text)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", file, number, err.Error())
+		}
+		if len(words) > 2 {
+			return nil, fmt.Errorf("%s:%d: expected a single argument, use quotes: %s", file, number, text)
+		}
+
+		line := ConfigLine{
+			File: file,
+			Line: number,
+			Flag: strings.TrimPrefix(words[0], "--"),
+		}
+		if len(words) == 2 {
+			line.Argument = words[1]
+		}
+
+		result = append(result, line)
+	}
+
+	return result, scanner.Err()
+}
+
+// Split a line into words like a shell would, taking single and double
+// quotes and backslash escapes into account
+func SplitWords(line string) ([]string, error) {
+	words := []string{}
+	var word strings.Builder
+	var quote rune
+	var escaped, inWord bool
+
+	for _, r := range line {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+			inWord = true
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			word.WriteRune(r)
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote %c", quote)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+
+	return words, nil
+}
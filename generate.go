@@ -0,0 +1,425 @@
+// +build linux
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gitea.icts.kuleuven.be/ceif-lnx/go-nhc/utils"
+	linuxproc "github.com/c9s/goprocinfo/linux"
+)
+
+var (
+	// Filesystems that are marked nodev in /proc/filesystems, but are not virtual
+	networkFileSystems = []string{"nfs", "nfs4", "cifs", "smb3", "lustre", "gpfs", "beegfs", "ceph", "glusterfs"}
+
+	serviceCgroupRegex = regexp.MustCompile(`/system\.slice/(?:[^/]+\.slice/)*([^/]+)\.service$`)
+)
+
+// Print a config file for /etc/nhc.conf, describing the current state of the node
+func Generate() {
+	c := &Context{}
+
+	generators := []func() ([]string, error){
+		c.GenerateInterfaces,
+		c.GenerateInfiniband,
+		c.GenerateMounts,
+		c.GenerateDimms,
+		c.GenerateCPU,
+		c.GenerateProcesses,
+		c.GeneratePorts,
+	}
+
+	for _, generator := range generators {
+		lines, err := generator()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not inspect node: %s\n", err.Error())
+			continue
+		}
+		for _, line := range lines {
+			fmt.Println(line)
+		}
+	}
+
+	os.Exit(0)
+}
+
+// Format a line of the config file, quoting the argument if needed
+func ConfigString(flag string, argument string) string {
+	if strings.ContainsAny(argument, " \t\"'\\$") {
+		return fmt.Sprintf("%s '%s'", flag, strings.ReplaceAll(argument, "'", `'\''`))
+	}
+	return fmt.Sprintf("%s %s", flag, argument)
+}
+
+func (c *Context) GenerateInterfaces() ([]string, error) {
+	files, err := filepath.Glob("/sys/class/net/*")
+	if err != nil {
+		return nil, err
+	}
+
+	result := []string{}
+	for _, file := range files {
+		iface := filepath.Base(file)
+
+		// Virtual ethernet pairs of containers come and go
+		if strings.HasPrefix(iface, "veth") {
+			continue
+		}
+
+		state, err := utils.ReadString(fmt.Sprintf("%s/operstate", file))
+		if err != nil {
+			continue
+		}
+
+		if iface == "lo" || state == "up" {
+			result = append(result, ConfigString("interface", iface))
+		}
+	}
+
+	return result, nil
+}
+
+func (c *Context) GenerateInfiniband() ([]string, error) {
+	ports, err := filepath.Glob("/sys/class/infiniband/*/ports/*")
+	if err != nil {
+		return nil, err
+	}
+
+	result := []string{}
+	for _, port := range ports {
+		state, err := utils.ReadString(fmt.Sprintf("%s/state", port))
+		if err != nil || !strings.Contains(state, "ACTIVE") {
+			continue
+		}
+
+		rate, err := utils.ReadString(fmt.Sprintf("%s/rate", port))
+		if err != nil {
+			continue
+		}
+
+		// e.g. 100 Gb/sec (4X EDR)
+		speed, err := strconv.Atoi(strings.SplitN(rate, " ", 2)[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Skipping %s, unsupported rate: %s\n", port, rate)
+			continue
+		}
+
+		device := filepath.Base(filepath.Dir(filepath.Dir(port)))
+		result = append(result, InfinibandConfig(device, filepath.Base(port), speed))
+	}
+
+	return result, nil
+}
+
+// The config line of an infiniband port. The port is part of the first word,
+// so that each port of a device gets its own label, e.g. ib_mlx5_0_1
+func InfinibandConfig(device string, port string, speed int) string {
+	return ConfigString("infiniband", fmt.Sprintf("%s:%s speed=%d", device, port, speed))
+}
+
+// List the filesystems that do not need a block device, these are virtual
+// filesystems like proc and tmpfs, but also network filesystems
+func ListNodevFileSystems() (map[string]bool, error) {
+	handle, err := os.Open("/proc/filesystems")
+	if err != nil {
+		return nil, err
+	}
+	defer handle.Close()
+
+	result := map[string]bool{}
+	scanner := bufio.NewScanner(handle)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == "nodev" {
+			result[fields[1]] = true
+		}
+	}
+
+	return result, scanner.Err()
+}
+
+func (c *Context) GenerateMounts() ([]string, error) {
+	nodev, err := ListNodevFileSystems()
+	if err != nil {
+		return nil, err
+	}
+	for _, fsType := range networkFileSystems {
+		delete(nodev, fsType)
+	}
+
+	mounts, err := c.Mounts()
+	if err != nil {
+		return nil, err
+	}
+
+	return MountConfig(mounts.Mounts, nodev), nil
+}
+
+// The config lines of the mounts, skipping filesystems that do not need a block device
+func MountConfig(mounts []linuxproc.Mount, nodev map[string]bool) []string {
+	result := []string{}
+	seen := map[string]int{}
+	for _, mount := range mounts {
+		if nodev[mount.FSType] || (strings.HasPrefix(mount.FSType, "fuse") && mount.FSType != "fuse.glusterfs") {
+			continue
+		}
+
+		argument := fmt.Sprintf("%s device=%s fs_type=%s", mount.MountPoint, mount.Device, mount.FSType)
+		if mount.Options != "rw" && !strings.HasPrefix(mount.Options, "rw,") {
+			argument += " read_only=yes"
+		}
+
+		// Only the last mount on a mount point is visible
+		if index, ok := seen[mount.MountPoint]; ok {
+			result[index] = ConfigString("mount", argument)
+			continue
+		}
+		seen[mount.MountPoint] = len(result)
+		result = append(result, ConfigString("mount", argument))
+	}
+
+	return result
+}
+
+func (c *Context) GenerateDimms() ([]string, error) {
+	channels, err := utils.ListMemoryChannels()
+	if err != nil {
+		return nil, err
+	}
+
+	// Without EDAC, dimms can't be checked
+	if len(channels) == 0 || len(channels[0].Dimms) == 0 {
+		return []string{}, nil
+	}
+
+	var count int
+	perChannel := len(channels[0].Dimms)
+	size := channels[0].Dimms[0].Size
+	for _, channel := range channels {
+		count += len(channel.Dimms)
+		if len(channel.Dimms) != perChannel {
+			perChannel = 0
+		}
+		for _, dimm := range channel.Dimms {
+			if dimm.Size != size {
+				size = 0
+			}
+		}
+	}
+
+	// The layout is only asserted completely if it is consistent
+	if perChannel == 0 || size == 0 {
+		return []string{ConfigString("dimms", fmt.Sprintf("count=%d", count))}, nil
+	}
+
+	// Sizes in EDAC are expressed in MB
+	sizeString := fmt.Sprintf("%dMB", size)
+	if size%1024 == 0 {
+		sizeString = fmt.Sprintf("%dGB", size/1024)
+	}
+
+	argument := fmt.Sprintf("consistent count=%d size=%s per_channel=%d", count, sizeString, perChannel)
+	return []string{ConfigString("dimms", argument)}, nil
+}
+
+func (c *Context) GenerateCPU() ([]string, error) {
+	cpuInfo, err := c.CPUInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	active, err := c.SMTActive()
+	if err != nil {
+		return nil, err
+	}
+
+	// Only assert the control state if hyperthreading can't be enabled at runtime
+	state := ActiveString(active)
+	if control := SMTControl(); control == "forceoff" || control == "notsupported" {
+		state += fmt.Sprintf(" control=%s", control)
+	}
+
+	return []string{
+		ConfigString("cpu-sockets", strconv.Itoa(cpuInfo.NumPhysicalCPU())),
+		ConfigString("hyperthreading", state),
+	}, nil
+}
+
+// Find the systemd service of a process, based on its cgroup
+func ServiceOfProcess(pid uint64) string {
+	b, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/cgroup", pid))
+	if err != nil {
+		return ""
+	}
+
+	// Format: <ID>:<CONTROLLERS>:<PATH>, a single 0::<PATH> line for cgroup v2
+	for _, line := range strings.Split(string(b), "\n") {
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		if match := serviceCgroupRegex.FindStringSubmatch(parts[2]); match != nil {
+			return match[1]
+		}
+	}
+
+	return ""
+}
+
+func (c *Context) GenerateProcesses() ([]string, error) {
+	psInfo, err := c.Processes()
+	if err != nil {
+		return nil, err
+	}
+
+	services := map[uint64]string{}
+	uids := map[string]map[uint64]bool{}
+	for _, pStatus := range psInfo {
+		services[pStatus.Pid] = ServiceOfProcess(pStatus.Pid)
+
+		if uids[pStatus.Name] == nil {
+			uids[pStatus.Name] = map[uint64]bool{}
+		}
+		uids[pStatus.Name][pStatus.EffectiveUid] = true
+	}
+
+	daemons := map[string]*linuxproc.ProcessStatus{}
+	for _, pStatus := range psInfo {
+		service := services[pStatus.Pid]
+
+		// The main process of a service is not started by another process of the same service
+		if service == "" || services[uint64(pStatus.PPid)] == service {
+			continue
+		}
+		if _, ok := daemons[service]; !ok {
+			daemons[service] = pStatus
+		}
+	}
+
+	names := []string{}
+	for service := range daemons {
+		names = append(names, service)
+	}
+	sort.Strings(names)
+
+	result := []string{}
+	for _, service := range names {
+		pStatus := daemons[service]
+
+		argument := service
+		if pStatus.Name != service {
+			argument += fmt.Sprintf(" daemon=%s", pStatus.Name)
+		}
+
+		// The number of processes with the same name, e.g. sshd, changes over time,
+		// and the user can only be checked if all of them run under the same user
+		var count int
+		for _, other := range psInfo {
+			if other.Name == pStatus.Name {
+				count++
+			}
+		}
+		if count > 1 {
+			argument += " count=0"
+		}
+
+		if len(uids[pStatus.Name]) == 1 {
+			u, err := user.LookupId(strconv.FormatUint(pStatus.EffectiveUid, 10))
+			if err == nil {
+				argument += fmt.Sprintf(" user=%s", u.Username)
+			}
+		}
+
+		result = append(result, ConfigString("process", argument))
+	}
+
+	return result, nil
+}
+
+// The range of ports the kernel assigns to e.g. rpc services at random
+func EphemeralPortRange() (uint64, uint64) {
+	value, err := utils.ReadString("/proc/sys/net/ipv4/ip_local_port_range")
+	if err != nil {
+		return 32768, 60999
+	}
+
+	fields := strings.Fields(value)
+	if len(fields) != 2 {
+		return 32768, 60999
+	}
+
+	low, err1 := strconv.ParseUint(fields[0], 10, 16)
+	high, err2 := strconv.ParseUint(fields[1], 10, 16)
+	if err1 != nil || err2 != nil {
+		return 32768, 60999
+	}
+
+	return low, high
+}
+
+func (c *Context) GeneratePorts() ([]string, error) {
+	low, high := EphemeralPortRange()
+	ports := map[uint64]bool{}
+
+	decoders := map[string]linuxproc.NetIPDecoder{
+		"/proc/net/tcp":  linuxproc.NetIPv4Decoder,
+		"/proc/net/tcp6": linuxproc.NetIPv6Decoder,
+	}
+
+	for file, decoder := range decoders {
+		sockets, err := linuxproc.ReadNetTCPSockets(file, decoder)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		for _, socket := range sockets.Sockets {
+			// Only listening sockets (TCP_LISTEN)
+			if socket.Status != 0x0A {
+				continue
+			}
+
+			index := strings.LastIndex(socket.LocalAddress, ":")
+			address := socket.LocalAddress[:index]
+			port, err := strconv.ParseUint(socket.LocalAddress[index+1:], 10, 16)
+			if err != nil {
+				continue
+			}
+
+			// The port check connects to localhost
+			switch address {
+			case "0.0.0.0", "127.0.0.1", "::", "::1":
+			default:
+				continue
+			}
+
+			if port < low || port > high {
+				ports[port] = true
+			}
+		}
+	}
+
+	sorted := []int{}
+	for port := range ports {
+		sorted = append(sorted, int(port))
+	}
+	sort.Ints(sorted)
+
+	result := []string{}
+	for _, port := range sorted {
+		result = append(result, ConfigString("port", strconv.Itoa(port)))
+	}
+
+	return result, nil
+}
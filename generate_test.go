@@ -0,0 +1,73 @@
+// +build linux
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	linuxproc "github.com/c9s/goprocinfo/linux"
+)
+
+func TestGenerateRegister(t *testing.T) {
+	lines := []string{
+		InfinibandConfig("mlx5_0", "1", 100),
+		InfinibandConfig("mlx5_0", "2", 100),
+		InfinibandConfig("mlx5_1", "1", 200),
+	}
+	lines = append(lines, MountConfig([]linuxproc.Mount{
+		{Device: "/dev/sda1", MountPoint: "/", FSType: "ext4", Options: "rw,relatime"},
+		{Device: "proc", MountPoint: "/proc", FSType: "proc", Options: "rw"},
+		{Device: "/dev/sda5", MountPoint: "/local_scratch", FSType: "xfs", Options: "rw"},
+		{Device: "server:/apps", MountPoint: "/apps", FSType: "nfs4", Options: "ro"},
+		{Device: "server:/apps/v2", MountPoint: "/apps", FSType: "nfs4", Options: "rw"},
+	}, map[string]bool{"proc": true})...)
+
+	dir, err := ioutil.TempDir("", "go-nhc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "nhc.conf")
+	if err := ioutil.WriteFile(file, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := ReadConfig(file)
+	if err != nil {
+		t.Fatalf("could not read generated config: %s", err.Error())
+	}
+
+	c := &Context{
+		checks: []LabeledCheck{},
+	}
+	for _, err := range c.RegisterConfig(config) {
+		t.Errorf("generated config is not valid: %s", err.Error())
+	}
+
+	labels := []string{}
+	for _, check := range c.checks {
+		labels = append(labels, check.Label)
+	}
+
+	// Generated lines get labels of their own, without numbering duplicates
+	expected := []string{"ib_mlx5_0_1", "ib_mlx5_0_2", "ib_mlx5_1_1", "mount_", "mount_local_scratch", "mount_apps"}
+	if !reflect.DeepEqual(labels, expected) {
+		t.Errorf("expected labels %v, got %v", expected, labels)
+	}
+
+	port := c.checks[1].Metadata.(*InfinibandMetadata)
+	if port.Device != "mlx5_0" || port.Port != 2 || port.Speed != 100 {
+		t.Errorf("expected port 2 of mlx5_0 at 100, got %+v", port)
+	}
+
+	mount := c.checks[5].Metadata.(*MountMetadata)
+	if mount.Device != "server:/apps/v2" || mount.ReadOnly {
+		t.Errorf("expected the last mount on /apps, got %+v", mount)
+	}
+}
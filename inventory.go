@@ -0,0 +1,234 @@
+// +build linux
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gitea.icts.kuleuven.be/ceif-lnx/go-nhc/utils"
+)
+
+var (
+	// Files in /sys/class/dmi/id that identify the system and its firmware
+	dmiFields = map[string]string{
+		"bios.vendor":    "bios_vendor",
+		"bios.version":   "bios_version",
+		"bios.date":      "bios_date",
+		"system.vendor":  "sys_vendor",
+		"system.product": "product_name",
+		"board.name":     "board_name",
+	}
+)
+
+type InventoryMetadata struct {
+	Baseline string
+}
+
+// Hardware inventory of the node, as a flat map so that it can easily be compared
+type Inventory map[string]string
+
+func (c *Context) ReadInventory() (Inventory, error) {
+	inventory := Inventory{}
+
+	cpuInfo, err := c.CPUInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	models := []string{}
+	for _, processor := range cpuInfo.Processors {
+		if len(models) == 0 || models[len(models)-1] != processor.ModelName {
+			models = append(models, processor.ModelName)
+		}
+	}
+	inventory["cpu.model"] = strings.Join(models, ",")
+	inventory["cpu.sockets"] = strconv.Itoa(cpuInfo.NumPhysicalCPU())
+	inventory["cpu.count"] = strconv.Itoa(cpuInfo.NumCPU())
+
+	steppings, err := utils.ListCPUInfoValues(cpuinfo_file, "stepping")
+	if err != nil {
+		return nil, err
+	}
+	if len(steppings) > 0 {
+		inventory["cpu.stepping"] = strings.Join(steppings, ",")
+	}
+
+	channels, err := utils.ListMemoryChannels()
+	if err != nil {
+		return nil, err
+	}
+	for _, channel := range channels {
+		for _, dimm := range channel.Dimms {
+			inventory[fmt.Sprintf("dimm.%s.%s", channel.Name, dimm.Name)] = strconv.FormatUint(dimm.Size, 10)
+		}
+	}
+
+	devices, err := filepath.Glob("/sys/class/infiniband/*")
+	if err != nil {
+		return nil, err
+	}
+	for _, device := range devices {
+		for _, field := range []string{"fw_ver", "board_id"} {
+			if value, err := utils.ReadString(fmt.Sprintf("%s/%s", device, field)); err == nil {
+				inventory[fmt.Sprintf("ib.%s.%s", filepath.Base(device), field)] = value
+			}
+		}
+	}
+
+	// Only interfaces backed by a device, not virtual ones
+	ifaces, err := filepath.Glob("/sys/class/net/*/device")
+	if err != nil {
+		return nil, err
+	}
+	for _, iface := range ifaces {
+		vendor, err1 := utils.ReadString(fmt.Sprintf("%s/vendor", iface))
+		device, err2 := utils.ReadString(fmt.Sprintf("%s/device", iface))
+		if err1 == nil && err2 == nil {
+			inventory[fmt.Sprintf("net.%s", filepath.Base(filepath.Dir(iface)))] = fmt.Sprintf("%s:%s", vendor, device)
+		}
+	}
+
+	for key, file := range dmiFields {
+		if value, err := utils.ReadString(fmt.Sprintf("/sys/class/dmi/id/%s", file)); err == nil {
+			inventory[key] = strings.TrimSpace(value)
+		}
+	}
+
+	return inventory, nil
+}
+
+// Load an inventory saved by Save. A missing file results in a nil inventory
+func LoadInventory(file string) (Inventory, error) {
+	b, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	inventory := Inventory{}
+	err = json.Unmarshal(b, &inventory)
+	return inventory, err
+}
+
+func (inventory Inventory) Save(file string) error {
+	b, err := json.MarshalIndent(inventory, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+
+	return WriteFileAtomic(file, b, 0644)
+}
+
+// List the differences with another inventory, as '<KEY>: <OLD> -> <NEW>'
+func (inventory Inventory) Diff(other Inventory) []string {
+	keys := []string{}
+	for key := range inventory {
+		keys = append(keys, key)
+	}
+	for key := range other {
+		if _, ok := inventory[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	result := []string{}
+	for _, key := range keys {
+		before, ok1 := inventory[key]
+		after, ok2 := other[key]
+
+		if !ok1 {
+			before = "(missing)"
+		}
+		if !ok2 {
+			after = "(missing)"
+		}
+		if before != after {
+			result = append(result, fmt.Sprintf("%s: %s -> %s", key, before, after))
+		}
+	}
+
+	return result
+}
+
+func (c *Context) CheckInventory(argument string, common CommonMetadata) (Check, interface{}, error) {
+	m := &InventoryMetadata{}
+	err := ParseMetadata(m, argument, "Baseline")
+	if err != nil {
+		return nil, nil, err
+	}
+	if m.Baseline == "" {
+		return nil, nil, fmt.Errorf("no baseline file given")
+	}
+
+	return func(r *Report) (Status, string) {
+		current, err := c.ReadInventory()
+		if err != nil {
+			return Unknown, fmt.Sprintf("Could not read inventory: %s", err.Error())
+		}
+
+		baseline, err := LoadInventory(m.Baseline)
+		if err != nil {
+			return Unknown, fmt.Sprintf("Could not load inventory baseline %s: %s", m.Baseline, err.Error())
+		}
+
+		// Recording a new baseline is a deliberate action, see RecordInventory
+		if baseline == nil {
+			err = current.Save(m.Baseline)
+			if err != nil {
+				return Unknown, fmt.Sprintf("Could not save inventory baseline %s: %s", m.Baseline, err.Error())
+			}
+			return OK, fmt.Sprintf("Recorded inventory baseline in %s", m.Baseline)
+		}
+
+		diff := baseline.Diff(current)
+		if len(diff) > 0 {
+			return Critical, fmt.Sprintf("Inventory differs from baseline %s: %s", m.Baseline, strings.Join(diff, ", "))
+		}
+
+		return OK, ""
+	}, m, nil
+}
+
+// Record the inventory of the node as the new baseline, e.g. after a deliberate hardware change
+func RecordInventory(file string) {
+	c := &Context{}
+
+	inventory, err := c.ReadInventory()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	baseline, err := LoadInventory(file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not load inventory baseline %s, overwriting it: %s\n", file, err.Error())
+	}
+
+	// List what changed since the previous baseline, if any
+	if baseline != nil {
+		for _, line := range baseline.Diff(inventory) {
+			fmt.Println(line)
+		}
+	}
+
+	err = inventory.Save(file)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("Recorded inventory baseline in %s\n", file)
+	os.Exit(0)
+}
@@ -0,0 +1,103 @@
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"log/syslog"
+
+	"gitea.icts.kuleuven.be/ceif-lnx/go-nhc/utils"
+)
+
+// Logs results locally to syslog or journald
+type LogSender struct {
+	Target string
+	All    bool
+	RunID  string
+}
+
+func NewLogSender(target string, all bool, runID string) *LogSender {
+	return &LogSender{
+		Target: target,
+		All:    all,
+		RunID:  runID,
+	}
+}
+
+// Log the results. Entries that were logged are not logged again when the
+// batch is retried, see PartialSendError
+func (l *LogSender) SendResults(results []*Result) error {
+	var writer *syslog.Writer
+	if l.Target == "syslog" {
+		var err error
+		writer, err = syslog.New(syslog.LOG_DAEMON|syslog.LOG_INFO, programName)
+		if err != nil {
+			return err
+		}
+		defer writer.Close()
+	}
+
+	for i, result := range results {
+		if result.Status == OK && !l.All {
+			continue
+		}
+
+		var err error
+		switch l.Target {
+		case "syslog":
+			err = l.syslog(writer, result)
+		case "journald":
+			err = l.journald(result)
+		}
+		if err != nil {
+			return &PartialSendError{
+				Sent: i,
+				Err:  err,
+			}
+		}
+	}
+
+	return nil
+}
+
+// Log a result with the priority matching its status, the facility is set by the writer
+func (l *LogSender) syslog(writer *syslog.Writer, result *Result) error {
+	message := fmt.Sprintf("%s: [%s] %s", result.Status.String(), result.Label, result.Message)
+
+	switch LogPriority(result.Status) {
+	case syslog.LOG_INFO:
+		return writer.Info(message)
+	case syslog.LOG_WARNING:
+		return writer.Warning(message)
+	case syslog.LOG_ERR:
+		return writer.Err(message)
+	}
+	return writer.Notice(message)
+}
+
+func (l *LogSender) journald(result *Result) error {
+	message := fmt.Sprintf("%s: [%s] %s", result.Status.String(), result.Label, result.Message)
+
+	return utils.JournalSend(message, int(LogPriority(result.Status)), map[string]string{
+		"SYSLOG_IDENTIFIER": programName,
+		"NHC_CHECK":         result.Label,
+		"NHC_TYPE":          result.Type,
+		"NHC_ARGUMENT":      result.Argument,
+		"NHC_STATUS":        result.Status.String(),
+		"NHC_MESSAGE":       result.Message,
+		"NHC_RUN_ID":        l.RunID,
+	})
+}
+
+// Translate a Status in a syslog priority
+func LogPriority(s Status) syslog.Priority {
+	switch s {
+	case OK:
+		return syslog.LOG_INFO
+	case Warning:
+		return syslog.LOG_WARNING
+	case Critical:
+		return syslog.LOG_ERR
+	}
+	return syslog.LOG_NOTICE
+}
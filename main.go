@@ -4,8 +4,11 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"path"
 	"regexp"
 	"strings"
+	"sync"
+	"time"
 
 	"gitea.icts.kuleuven.be/ceif-lnx/go-nhc/utils"
 	linuxproc "github.com/c9s/goprocinfo/linux"
@@ -22,111 +25,478 @@ type Context struct {
 	cpuInfo *linuxproc.CPUInfo
 	psInfo  []*linuxproc.ProcessStatus
 	jobInfo []utils.Job
-	sensu   *SensuClient
+	sinks   []*Sink
+	state   *State
+	runID   string
+	partial bool       // Whether only a selection of the checks is run
+	lock    sync.Mutex // Protects the information shared between checks, see cache.go
 }
 
 type LabeledCheck struct {
-	Label string
-	Check Check
+	Label    string
+	Type     string
+	Argument string
+	Source   string
+	Check    Check
+	Metadata interface{}
+	CommonMetadata
 }
 
 func main() {
-	_, err := fApp.Parse(os.Args[1:])
+	command, err := fApp.Parse(os.Args[1:])
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	switch command {
+	case fStatusCommand.FullCommand():
+		ShowStatus(*fStateFile, *fList)
+	case fValidateCommand.FullCommand():
+		Validate(*fValidateConfig)
+	case fExplainCommand.FullCommand():
+		Explain(*fExplainConfig, *fExplainLabel)
+	case fGenerateCommand.FullCommand():
+		Generate()
+	case fRecordInventoryCommand.FullCommand():
+		RecordInventory(*fRecordInventoryFile)
+	default:
+		RunCheckCommand()
+	}
+}
+
+// Run the checks given as flags, and report the results to the sinks
+func RunCheckCommand() {
+	var err error
+
 	context := Context{
 		checks: []LabeledCheck{},
+		runID:  NewRunID(),
+	}
+
+	// Changes can only be detected by comparing with the state of the previous run
+	if *fWebhookURL != "" && *fWebhookOn == "change" && *fStateFile == "" {
+		log.Fatal("--webhook-on=change requires a --state-file")
+	}
+
+	if *fStateFile != "" {
+		context.state, err = LoadState(*fStateFile)
+		if err != nil {
+			// Start over, the corrupt state file is overwritten after this run
+			fmt.Fprintf(os.Stderr, "Could not load state from %s, starting with an empty state: %s\n", *fStateFile, err.Error())
+			context.state = &State{
+				Checks: map[string]*CheckState{},
+			}
+		}
 	}
 
 	if !*fNoSend {
-		context.sensu = NewSensuClient(*fSensuAddr)
-	}
-
-	context.RegisterEach("interface_%s", context.CheckInterface, *fCheckInterfaces)
-	context.RegisterEach("ib_%s", context.CheckInfiniband, *fCheckInfinibands)
-	context.RegisterEach("mount_%s", context.CheckMount, *fCheckMounts)
-	context.RegisterEach("du_%s", context.CheckDiskUsage, *fCheckDiskUsages)
-	context.RegisterEach("file_%s", context.CheckFile, *fCheckFiles)
-	context.RegisterEach("user_%s", context.CheckUser, *fCheckUsers)
-	context.RegisterEach("ps_%s", context.CheckProcess, *fCheckProcesses)
-	context.RegisterEach("port_%s", context.CheckPort, *fCheckPorts)
-	context.RegisterEach("cmd_%s", context.CheckCommand, *fCheckCommands)
-	context.Register("mem_phys", context.CheckFreeMemory, *fCheckFreeMemory)
-	context.Register("mem_swap", context.CheckFreeSwap, *fCheckFreeSwap)
-	context.Register("mem_total", context.CheckFreeTotalMemory, *fCheckFreeTotalMemory)
-	context.Register("mem_dimms", context.CheckDimms, *fCheckDimms)
-	context.Register("cpu_sockets", context.CheckCPUSockets, *fCheckCPUSockets)
-	context.Register("cpu_hyperthreading", context.CheckHyperthreading, *fCheckHyperthreading)
-	context.Register("ps_unauthorized", context.CheckUnauthorized, *fCheckUnauthorized)
-
-	context.RunChecks(*fVerbose, *fList, *fAll)
-}
-
-func (c *Context) RegisterEach(id_format string, factory CheckFactory, arguments []string) {
-	for _, argument := range arguments {
-		if argument == "" {
-			continue
+		var sensu ResultSender
+		switch *fSensuBackend {
+		case "classic":
+			sensu = NewSensuClient(*fSensuAddr)
+		case "go":
+			sensu, err = NewSensuGoClient(*fSensuURL, *fSensuInterval, *fSensuTTL, *fSensuHandlers, *fSensuLabels)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		context.AddSink("sensu", sensu, *fSpoolDir)
+	}
+
+	if *fWebhookURL != "" {
+		webhook, err := NewWebhookClient(*fWebhookURL, *fWebhookHeaders, *fWebhookTemplate, *fWebhookCert, *fWebhookKey, *fWebhookCA, *fWebhookOn == "change", context.runID)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		// A webhook summarizes a single run, so results of earlier runs are only
+		// spooled if they contain state changes, which are not detected again
+		spoolDir := ""
+		if *fWebhookOn == "change" {
+			spoolDir = *fSpoolDir
 		}
-		id := fmt.Sprintf(id_format, ArgumentToId(argument))
-		c.Register(id, factory, argument)
+		context.AddSink("webhook", webhook, spoolDir)
+	}
+
+	if *fLog != "none" {
+		context.AddSink(*fLog, NewLogSender(*fLog, *fLogAll, context.runID), "")
+	}
+
+	err = context.RegisterAll()
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(127)
+	}
+
+	err = context.ResolveDependencies()
+	if err != nil {
+		fmt.Printf("Parse error: %s\n", err.Error())
+		os.Exit(127)
+	}
+
+	err = context.SelectChecks(*fOnly, *fSkip)
+	if err != nil {
+		fmt.Printf("Parse error: %s\n", err.Error())
+		os.Exit(127)
+	}
+
+	context.RunChecks(*fVerbose, *fList, *fAll, *fOutput)
+}
+
+// A type of check, as given on the command line or in the config file
+type CheckDefinition struct {
+	Flag      string
+	ID        string // Label of the check, %s is replaced by the first word of the argument
+	Factory   CheckFactory
+	Arguments []string
+	External  bool // Whether the check runs an external command, which only takes trailing metadata
+}
+
+func (c *Context) Definitions() []CheckDefinition {
+	return []CheckDefinition{
+		{"interface", "interface_%s", c.CheckInterface, *fCheckInterfaces, false},
+		{"infiniband", "ib_%s", c.CheckInfiniband, *fCheckInfinibands, false},
+		{"mount", "mount_%s", c.CheckMount, *fCheckMounts, false},
+		{"disk-usage", "du_%s", c.CheckDiskUsage, *fCheckDiskUsages, false},
+		{"file", "file_%s", c.CheckFile, *fCheckFiles, false},
+		{"symlink", "symlink_%s", c.CheckSymlink, *fCheckSymlinks, false},
+		{"directory", "dir_%s", c.CheckDirectory, *fCheckDirectories, false},
+		{"user", "user_%s", c.CheckUser, *fCheckUsers, false},
+		{"process", "ps_%s", c.CheckProcess, *fCheckProcesses, false},
+		{"port", "port_%s", c.CheckPort, *fCheckPorts, false},
+		{"command", "cmd_%s", c.CheckCommand, *fCheckCommands, true},
+		{"plugin", "plugin_%s", c.CheckPlugin, *fCheckPlugins, true},
+		{"memory", "mem_phys", c.CheckFreeMemory, []string{*fCheckFreeMemory}, false},
+		{"swap", "mem_swap", c.CheckFreeSwap, []string{*fCheckFreeSwap}, false},
+		{"total-memory", "mem_total", c.CheckFreeTotalMemory, []string{*fCheckFreeTotalMemory}, false},
+		{"memory-total", "mem_installed", c.CheckMemoryTotal, []string{*fCheckMemoryTotal}, false},
+		{"numa", "mem_numa", c.CheckNuma, []string{*fCheckNuma}, false},
+		{"dimms", "mem_dimms", c.CheckDimms, []string{*fCheckDimms}, false},
+		{"ecc", "mem_ecc", c.CheckEcc, []string{*fCheckEcc}, false},
+		{"cpu-sockets", "cpu_sockets", c.CheckCPUSockets, []string{*fCheckCPUSockets}, false},
+		{"hyperthreading", "cpu_hyperthreading", c.CheckHyperthreading, []string{*fCheckHyperthreading}, false},
+		{"cpu", "cpu_info", c.CheckCPU, []string{*fCheckCPU}, false},
+		{"cpufreq", "cpu_freq", c.CheckCPUFreq, []string{*fCheckCPUFreq}, false},
+		{"thermal", "thermal", c.CheckThermal, []string{*fCheckThermal}, false},
+		{"inventory", "inventory", c.CheckInventory, []string{*fCheckInventory}, false},
+		{"unauthorized", "ps_unauthorized", c.CheckUnauthorized, []string{*fCheckUnauthorized}, false},
 	}
 }
 
-func (c *Context) Register(id string, factory CheckFactory, argument string) {
+// Register the checks given on the command line
+func (c *Context) RegisterAll() error {
+	for _, definition := range c.Definitions() {
+		for _, argument := range definition.Arguments {
+			err := c.RegisterDefinition(definition, argument)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (c *Context) RegisterDefinition(definition CheckDefinition, argument string) error {
+	if argument == "" {
+		return nil
+	}
+	id := definition.ID
+	if strings.Contains(id, "%s") {
+		id = fmt.Sprintf(id, ArgumentToId(argument))
+	}
+	return c.Register(id, definition, argument)
+}
+
+func (c *Context) Register(id string, definition CheckDefinition, argument string) error {
 	if argument == "" {
-		return
+		return nil
 	}
-	check, err := factory(argument)
+	common := NewCommonMetadata()
+	remainder, err := ParseCommonMetadata(&common, argument, definition.External)
 	if err != nil {
-		fmt.Printf("[%s] Parse error: %s\n", id, err.Error())
-		os.Exit(127)
+		return fmt.Errorf("[%s] Parse error: %s", id, err.Error())
+	}
+	if common.Label != "" {
+		if c.HasLabel(common.Label) {
+			return fmt.Errorf("[%s] Parse error: duplicate label", common.Label)
+		}
+		id = common.Label
+	} else if c.HasLabel(id) {
+		// Generated labels only use the first word of the argument, e.g. cmd_test,
+		// but state and dependencies are keyed by label
+		for i := 2; ; i++ {
+			if unique := fmt.Sprintf("%s_%d", id, i); !c.HasLabel(unique) {
+				id = unique
+				break
+			}
+		}
+	}
+	check, metadata, err := definition.Factory(remainder, common)
+	if err != nil {
+		return fmt.Errorf("[%s] Parse error: %s", id, err.Error())
+	}
+
+	// External commands apply the timeout themselves, and kill the command when it expires
+	wrapper := common
+	if definition.External {
+		wrapper.Timeout = 0
 	}
 	c.checks = append(c.checks, LabeledCheck{
-		Label: id,
-		Check: check,
+		Label:          id,
+		Type:           definition.Flag,
+		Argument:       argument,
+		Check:          wrapper.Wrap(check),
+		Metadata:       metadata,
+		CommonMetadata: common,
 	})
+	return nil
+}
+
+// Whether a check with the given label is registered
+func (c *Context) HasLabel(label string) bool {
+	for _, check := range c.checks {
+		if check.Label == label {
+			return true
+		}
+	}
+	return false
+}
+
+// Order the checks so that each check runs after the checks it depends on,
+// and fail on unknown labels and dependency cycles
+func (c *Context) ResolveDependencies() error {
+	indices := map[string]int{}
+	for i, check := range c.checks {
+		indices[check.Label] = i
+	}
+
+	const (
+		visiting = 1
+		visited  = 2
+	)
+
+	state := make([]int, len(c.checks))
+	ordered := make([]LabeledCheck, 0, len(c.checks))
+
+	var visit func(i int, path []string) error
+	visit = func(i int, path []string) error {
+		check := c.checks[i]
+		switch state[i] {
+		case visited:
+			return nil
+		case visiting:
+			return fmt.Errorf("[%s] dependency cycle: %s", check.Label, strings.Join(append(path, check.Label), " -> "))
+		}
+
+		state[i] = visiting
+		for _, label := range check.DependsOn {
+			dependency, ok := indices[label]
+			if !ok {
+				return fmt.Errorf("[%s] depends on unknown check %s", check.Label, label)
+			}
+			if err := visit(dependency, append(path, check.Label)); err != nil {
+				return err
+			}
+		}
+		state[i] = visited
+
+		ordered = append(ordered, check)
+		return nil
+	}
+
+	for i := range c.checks {
+		if err := visit(i, nil); err != nil {
+			return err
+		}
+	}
+
+	c.checks = ordered
+	return nil
+}
+
+// Only keep the checks that match one of the only patterns, if any, and that do
+// not match any of the skip patterns. A pattern is either a tag, or a glob
+// matched against the label
+func (c *Context) SelectChecks(only []string, skip []string) error {
+	selected := []LabeledCheck{}
+
+	for _, check := range c.checks {
+		match, err := check.Matches(only)
+		if err != nil {
+			return err
+		}
+		if len(only) > 0 && !match {
+			continue
+		}
+
+		match, err = check.Matches(skip)
+		if err != nil {
+			return err
+		}
+		if match {
+			continue
+		}
+
+		selected = append(selected, check)
+	}
+
+	// A pattern that matches nothing is most likely a typo
+	for _, pattern := range only {
+		if !c.MatchesAny(pattern) {
+			fmt.Fprintf(os.Stderr, "No check matches --only %s\n", pattern)
+		}
+	}
+	for _, pattern := range skip {
+		if !c.MatchesAny(pattern) {
+			fmt.Fprintf(os.Stderr, "No check matches --skip %s\n", pattern)
+		}
+	}
+
+	c.partial = len(selected) < len(c.checks)
+	c.checks = selected
+	return nil
+}
+
+// Whether any of the registered checks matches the given tag or label glob
+func (c *Context) MatchesAny(pattern string) bool {
+	for _, check := range c.checks {
+		if match, _ := check.Matches([]string{pattern}); match {
+			return true
+		}
+	}
+	return false
 }
 
-func (c *Context) RunChecks(verbose bool, list bool, all bool) {
+// Whether the check matches one of the given tags or label globs. The type of a
+// check is an implicit tag
+func (check LabeledCheck) Matches(patterns []string) (bool, error) {
+	for _, pattern := range patterns {
+		if pattern == check.Type {
+			return true, nil
+		}
+
+		for _, tag := range check.Tags {
+			if pattern == tag {
+				return true, nil
+			}
+		}
+
+		match, err := path.Match(pattern, check.Label)
+		if err != nil {
+			return false, fmt.Errorf("invalid pattern %s: %s", pattern, err.Error())
+		}
+		if match {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// Return the first dependency of a check that failed in this run. Only fatal
+// statuses count as failures, after applying failures_before_alert and
+// successes_before_clear. Dependencies that were not selected to run are not enforced
+func FailedDependency(check LabeledCheck, statuses map[string]Status) string {
+	for _, label := range check.DependsOn {
+		if status, ok := statuses[label]; ok && status.IsFatal() {
+			return label
+		}
+	}
+	return ""
+}
+
+func (c *Context) RunChecks(verbose bool, list bool, all bool, output string) {
 	var global Status
 	var failed int
+	var exit Status
+
+	results := []*Result{}
+	statuses := map[string]Status{}
+	text := output != "nagios"
 
 	for _, check := range c.checks {
-		status, message := check.Check()
+		var result *Result
+		var cached bool
 
-		if status == Ignore {
-			if verbose {
-				fmt.Printf("%s: [%s] %s\n", status.String(), check.Label, message)
+		if dependency := FailedDependency(check, statuses); dependency != "" {
+			// Checks depending on this one are skipped as well
+			statuses[check.Label] = statuses[dependency]
+			result = &Result{
+				Label:    check.Label,
+				Type:     check.Type,
+				Argument: check.Argument,
+				Status:   Ignore,
+				Message:  fmt.Sprintf("Skipped, dependency %s failed", dependency),
+				Time:     time.Now(),
 			}
-			continue
+		} else if result = c.state.Cached(check); result != nil {
+			cached = true
+		} else {
+			result = c.Run(check)
 		}
 
-		if c.sensu != nil {
-			err := c.sensu.SendResult(check.Label, status, message)
-			if err != nil && verbose {
-				fmt.Printf("Sending result of %s to sensu failed: %s\n", check.Label, err.Error())
-				c.sensu = nil
+		if result.Status == Ignore {
+			if verbose && text {
+				fmt.Printf("%s: [%s] %s\n", result.Status.String(), check.Label, result.Message)
 			}
+			continue
 		}
 
-		if !all && status.IsFatal() {
-			fmt.Printf("ERROR %s: [%s] %s\n", status.String(), check.Label, message)
-			os.Exit(status.RC())
-		} else if status != OK || list {
-			fmt.Printf("%s: [%s] %s\n", status.String(), check.Label, message)
+		if c.state != nil && !cached {
+			c.state.Update(result, check.FailuresBeforeAlert, check.SuccessesBeforeClear)
+			if result.Changed && verbose && text {
+				fmt.Printf("[%s] went %s at %s\n", result.Label, result.Status.String(), result.Since.Format("15:04:05"))
+			}
 		}
 
+		statuses[check.Label] = result.Status
+
+		status, message := result.Status, result.Message
+
+		results = append(results, result)
+
 		if status != OK {
 			failed++
 			if status.Compare(global) > 0 {
 				global = status
 			}
 		}
+
+		if !all && status.IsFatal() {
+			if text {
+				fmt.Printf("ERROR %s: [%s] %s\n", status.String(), check.Label, message)
+			}
+			exit = status
+			break
+		} else if text && (status != OK || list) {
+			fmt.Printf("%s: [%s] %s\n", status.String(), check.Label, message)
+		}
 	}
 
-	if verbose {
+	if c.state != nil {
+		// Checks that were not selected keep their state
+		if !c.partial {
+			c.state.Prune(c.checks)
+		}
+
+		err := c.state.Save(*fStateFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not save state to %s: %s\n", *fStateFile, err.Error())
+		}
+	}
+
+	for _, sink := range c.sinks {
+		err := sink.Deliver(results)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Sending results to %s failed: %s\n", sink.Name, err.Error())
+		}
+	}
+
+	if !text {
+		PrintNagios(os.Stdout, global, failed, results)
+	} else if exit != OK {
+		os.Exit(exit.RC())
+	} else if verbose {
 		if failed > 0 {
 			fmt.Printf("%d checks failed\n", failed)
 		} else {
@@ -137,6 +507,56 @@ func (c *Context) RunChecks(verbose bool, list bool, all bool) {
 	os.Exit(global.RC())
 }
 
+// Print the state of the checks as recorded during the last run, and exit with the worst status
+func ShowStatus(file string, all bool) {
+	if file == "" {
+		log.Fatal("status requires a --state-file")
+	}
+
+	state, err := LoadState(file)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var global Status
+	for _, check := range state.Checks {
+		if check.Status.Compare(global) > 0 {
+			global = check.Status
+		}
+	}
+
+	state.Print(os.Stdout, all)
+	os.Exit(global.RC())
+}
+
+func (c *Context) AddSink(name string, sender ResultSender, spoolDir string) {
+	c.sinks = append(c.sinks, NewSink(name, sender, *fSendRetries, *fSendBackoff, spoolDir, *fSpoolMax, *fSpoolMaxAge))
+}
+
+// Run a single check, and collect the metrics it reported
+func (c *Context) Run(check LabeledCheck) *Result {
+	r := &Report{}
+	if c.state != nil {
+		if previous, ok := c.state.Checks[check.Label]; ok {
+			r.previous = previous.Counters
+			r.previousTime = previous.LastRun
+		}
+	}
+
+	status, message := check.Check(r)
+
+	return &Result{
+		Label:    check.Label,
+		Type:     check.Type,
+		Argument: check.Argument,
+		Status:   status,
+		Message:  message,
+		Metrics:  r.Metrics,
+		Time:     time.Now(),
+		Counters: r.Counters,
+	}
+}
+
 func ArgumentToId(argument string) string {
 	parts := strings.SplitN(argument, " ", 2)
 	re := regexp.MustCompile(`[_/:]+`)
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRegisterLabels(t *testing.T) {
+	c := &Context{
+		checks: []LabeledCheck{},
+	}
+	definition := CheckDefinition{"command", "cmd_%s", c.CheckCommand, nil, true}
+
+	for _, argument := range []string{
+		"test -d /tmp",
+		"test -d /",
+		"test -e /etc/passwd label=cmd_test_3",
+		"test -e /etc/group",
+	} {
+		if err := c.RegisterDefinition(definition, argument); err != nil {
+			t.Fatalf("%s: unexpected error: %s", argument, err.Error())
+		}
+	}
+
+	labels := []string{}
+	for _, check := range c.checks {
+		labels = append(labels, check.Label)
+	}
+
+	expected := []string{"cmd_test", "cmd_test_2", "cmd_test_3", "cmd_test_4"}
+	if !reflect.DeepEqual(labels, expected) {
+		t.Errorf("expected labels %v, got %v", expected, labels)
+	}
+
+	// Explicit labels must be unique
+	if err := c.RegisterDefinition(definition, "true label=cmd_test_2"); err == nil {
+		t.Errorf("expected an error for a duplicate label")
+	}
+}
+
+func TestProcessFatal(t *testing.T) {
+	c := &Context{
+		checks: []LabeledCheck{},
+	}
+	definition := CheckDefinition{"process", "ps_%s", c.CheckProcess, nil, false}
+
+	// The name of a process is truncated to 15 characters
+	self := filepath.Base(os.Args[0])
+	if len(self) > 15 {
+		self = self[:15]
+	}
+
+	tests := []struct {
+		argument string
+		expected Status
+	}{
+		{"go-nhc-missing", Critical},
+		{"go-nhc-missing fatal=no", Warning},
+		{"go-nhc-missing fatal=no severity=critical", Warning},
+		// Only a missing process is demoted, not one running as the wrong user
+		{self + " user=nobody count=0 fatal=no", Critical},
+		{self + " user=nobody count=0 ignore=yes", Ignore},
+	}
+
+	for _, test := range tests {
+		c.checks = []LabeledCheck{}
+		if err := c.RegisterDefinition(definition, test.argument); err != nil {
+			t.Fatalf("%s: unexpected error: %s", test.argument, err.Error())
+		}
+
+		status, message := c.checks[0].Check(&Report{})
+		if status != test.expected {
+			t.Errorf("%s: expected %s, got %s: %s", test.argument, test.expected.String(), status.String(), message)
+		}
+	}
+}
+
+func TestRegisterUnknownKeys(t *testing.T) {
+	c := &Context{
+		checks: []LabeledCheck{},
+	}
+
+	for _, definition := range []CheckDefinition{
+		{"interface", "interface_%s", c.CheckInterface, nil, false},
+		{"user", "user_%s", c.CheckUser, nil, false},
+		{"port", "port_%s", c.CheckPort, nil, false},
+	} {
+		if err := c.RegisterDefinition(definition, "22 lable=x"); err == nil {
+			t.Errorf("%s: expected an error for an unknown key", definition.Flag)
+		}
+		if err := c.RegisterDefinition(definition, "22 tags=x"); err != nil {
+			t.Errorf("%s: unexpected error: %s", definition.Flag, err.Error())
+		}
+	}
+}
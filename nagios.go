@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type Metric struct {
+	Name     string  `json:"name"`
+	Value    float64 `json:"value"`
+	Unit     string  `json:"unit,omitempty"`
+	Warning  string  `json:"warning,omitempty"`
+	Critical string  `json:"critical,omitempty"`
+	Min      string  `json:"min,omitempty"`
+	Max      string  `json:"max,omitempty"`
+}
+
+var (
+	perfDataRegex  = regexp.MustCompile(`^('(?:[^']|'')+'|[^'= ]+)=([-+]?[0-9.]+(?:[eE][-+]?[0-9]+)?|U)([a-zA-Z%]*)((?:;[^; ]*){0,4})$`)
+	perfDataFields = regexp.MustCompile(`'(?:[^']|'')+'=\S*|\S+`)
+)
+
+// Format a metric as nagios performance data: 'label'=value[UOM];warn;crit;min;max
+func (m Metric) String() string {
+	name := m.Name
+	if strings.ContainsAny(name, " '=") {
+		name = fmt.Sprintf("'%s'", strings.ReplaceAll(name, "'", "''"))
+	}
+
+	s := fmt.Sprintf("%s=%s%s;%s;%s;%s;%s", name, strconv.FormatFloat(m.Value, 'f', -1, 64), m.Unit, m.Warning, m.Critical, m.Min, m.Max)
+	return strings.TrimRight(s, ";")
+}
+
+// Parse nagios performance data, i.e. a space separated list of
+// 'label'=value[UOM];warn;crit;min;max. Values that are unknown (U) are skipped.
+func ParsePerfData(perfdata string) ([]Metric, error) {
+	result := []Metric{}
+
+	for _, field := range perfDataFields.FindAllString(perfdata, -1) {
+		parts := perfDataRegex.FindStringSubmatch(field)
+		if parts == nil {
+			return nil, fmt.Errorf("could not parse performance data '%s'", field)
+		}
+
+		if parts[2] == "U" {
+			continue
+		}
+
+		value, err := strconv.ParseFloat(parts[2], 64)
+		if err != nil {
+			return nil, err
+		}
+
+		name := parts[1]
+		if strings.HasPrefix(name, "'") {
+			name = strings.ReplaceAll(name[1:len(name)-1], "''", "'")
+		}
+
+		m := Metric{
+			Name:  name,
+			Value: value,
+			Unit:  parts[3],
+		}
+
+		thresholds := strings.Split(parts[4], ";")
+		for index, target := range []*string{&m.Warning, &m.Critical, &m.Min, &m.Max} {
+			if index+1 < len(thresholds) {
+				*target = thresholds[index+1]
+			}
+		}
+
+		result = append(result, m)
+	}
+
+	return result, nil
+}
+
+// Translate the exit code of a nagios plugin to a Status
+func NagiosStatus(rc int) Status {
+	switch rc {
+	case 0:
+		return OK
+	case 1:
+		return Warning
+	case 2:
+		return Critical
+	default:
+		return Unknown
+	}
+}
+
+// Split the output of a nagios plugin into the message, i.e. the first line,
+// and the performance data. Performance data is found after a '|' on the first
+// line, and after the first '|' in the long output.
+func ParseNagiosOutput(output string) (string, []Metric, error) {
+	lines := strings.SplitN(strings.TrimSuffix(output, "\n"), "\n", 2)
+
+	parts := strings.SplitN(lines[0], "|", 2)
+	message := strings.TrimSpace(parts[0])
+	perfdata := []string{}
+
+	if len(parts) == 2 {
+		perfdata = append(perfdata, parts[1])
+	}
+
+	if len(lines) == 2 {
+		parts = strings.SplitN(lines[1], "|", 2)
+		if len(parts) == 2 {
+			perfdata = append(perfdata, parts[1])
+		}
+	}
+
+	metrics, err := ParsePerfData(strings.Join(perfdata, " "))
+	return message, metrics, err
+}
+
+// Make a message safe to print in nagios plugin output, in which a '|' starts the
+// performance data and each line is a separate check. Messages of commands
+// commonly contain shell pipes
+func NagiosEscape(message string) string {
+	return strings.NewReplacer("|", "¦", "\n", " ").Replace(message)
+}
+
+// Print results in the nagios plugin format: a summary line with the worst
+// status and the performance data of all checks, followed by a line per check
+func PrintNagios(w io.Writer, global Status, failed int, results []*Result) {
+	var summary string
+	var perfdata []string
+
+	for _, result := range results {
+		if result.Status != OK {
+			if summary != "" {
+				summary += ", "
+			}
+			summary += fmt.Sprintf("[%s] %s", result.Label, NagiosEscape(result.Message))
+		}
+
+		for _, metric := range result.Metrics {
+			metric.Name = fmt.Sprintf("%s.%s", result.Label, metric.Name)
+			perfdata = append(perfdata, metric.String())
+		}
+	}
+
+	if failed > 0 {
+		summary = fmt.Sprintf("%d of %d checks failed: %s", failed, len(results), summary)
+	} else {
+		summary = fmt.Sprintf("%d checks returned OK", len(results))
+	}
+
+	fmt.Fprintf(w, "NHC %s - %s", global.NagiosString(), summary)
+	if len(perfdata) > 0 {
+		fmt.Fprintf(w, " | %s", strings.Join(perfdata, " "))
+	}
+	fmt.Fprintln(w)
+
+	for _, result := range results {
+		fmt.Fprintf(w, "%s: [%s] %s\n", result.Status.String(), result.Label, NagiosEscape(result.Message))
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParsePerfData(t *testing.T) {
+	tests := []struct {
+		perfdata string
+		expected []Metric
+	}{
+		{
+			perfdata: "",
+			expected: []Metric{},
+		},
+		{
+			perfdata: "load1=0.5;15;30;0",
+			expected: []Metric{
+				{Name: "load1", Value: 0.5, Warning: "15", Critical: "30", Min: "0"},
+			},
+		},
+		{
+			perfdata: "time=0.012s used=45% rx=1.5e3c",
+			expected: []Metric{
+				{Name: "time", Value: 0.012, Unit: "s"},
+				{Name: "used", Value: 45, Unit: "%"},
+				{Name: "rx", Value: 1500, Unit: "c"},
+			},
+		},
+		{
+			// Quoted labels may contain spaces, and quotes are escaped by doubling them
+			perfdata: "'/ used'=10GB;;;0;100 'it''s'=-1",
+			expected: []Metric{
+				{Name: "/ used", Value: 10, Unit: "GB", Min: "0", Max: "100"},
+				{Name: "it's", Value: -1},
+			},
+		},
+		{
+			// Unknown values are skipped
+			perfdata: "a=U b=1",
+			expected: []Metric{
+				{Name: "b", Value: 1},
+			},
+		},
+		{
+			perfdata: "  a=1   b=2  ",
+			expected: []Metric{
+				{Name: "a", Value: 1},
+				{Name: "b", Value: 2},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		metrics, err := ParsePerfData(test.perfdata)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.perfdata, err.Error())
+			continue
+		}
+		if !reflect.DeepEqual(metrics, test.expected) {
+			t.Errorf("%s: expected %+v, got %+v", test.perfdata, test.expected, metrics)
+		}
+	}
+}
+
+func TestParsePerfDataErrors(t *testing.T) {
+	for _, perfdata := range []string{
+		"load",
+		"load=high",
+		"'unterminated=1",
+		"a=1;2;3;4;5;6",
+	} {
+		if _, err := ParsePerfData(perfdata); err == nil {
+			t.Errorf("%s: expected an error", perfdata)
+		}
+	}
+}
+
+func TestMetricString(t *testing.T) {
+	tests := []struct {
+		metric   Metric
+		expected string
+	}{
+		{Metric{Name: "load1", Value: 0.5}, "load1=0.5"},
+		{Metric{Name: "used", Value: 45, Unit: "%", Critical: "90", Min: "0", Max: "100"}, "used=45%;;90;0;100"},
+		{Metric{Name: "it's used", Value: 1}, "'it''s used'=1"},
+	}
+
+	for _, test := range tests {
+		if s := test.metric.String(); s != test.expected {
+			t.Errorf("expected %s, got %s", test.expected, s)
+		}
+
+		// Formatted metrics can be parsed again
+		metrics, err := ParsePerfData(test.metric.String())
+		if err != nil || len(metrics) != 1 || !reflect.DeepEqual(metrics[0], test.metric) {
+			t.Errorf("%s: could not parse formatted metric: %+v, %v", test.expected, metrics, err)
+		}
+	}
+}
+
+func TestParseNagiosOutput(t *testing.T) {
+	tests := []struct {
+		output   string
+		message  string
+		expected []Metric
+	}{
+		{
+			output:   "OK - load average: 0.50\n",
+			message:  "OK - load average: 0.50",
+			expected: []Metric{},
+		},
+		{
+			output:  "OK - load average: 0.50 | load1=0.5;15;30\n",
+			message: "OK - load average: 0.50",
+			expected: []Metric{
+				{Name: "load1", Value: 0.5, Warning: "15", Critical: "30"},
+			},
+		},
+		{
+			// Performance data in the long output follows the first '|'
+			output:  "DISK OK | '/'=10GB\nfree space on /\nfree space on /home | '/home'=20GB\n'/tmp'=1GB\n",
+			message: "DISK OK",
+			expected: []Metric{
+				{Name: "/", Value: 10, Unit: "GB"},
+				{Name: "/home", Value: 20, Unit: "GB"},
+				{Name: "/tmp", Value: 1, Unit: "GB"},
+			},
+		},
+		{
+			// Long output without performance data
+			output:   "WARNING - 2 problems\nfirst\nsecond\n",
+			message:  "WARNING - 2 problems",
+			expected: []Metric{},
+		},
+		{
+			output:   "",
+			message:  "",
+			expected: []Metric{},
+		},
+	}
+
+	for _, test := range tests {
+		message, metrics, err := ParseNagiosOutput(test.output)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %s", test.output, err.Error())
+			continue
+		}
+		if message != test.message {
+			t.Errorf("%q: expected message '%s', got '%s'", test.output, test.message, message)
+		}
+		if !reflect.DeepEqual(metrics, test.expected) {
+			t.Errorf("%q: expected %+v, got %+v", test.output, test.expected, metrics)
+		}
+	}
+}
+
+func TestPrintNagiosEscapesPipes(t *testing.T) {
+	results := []*Result{
+		{
+			Label:   "cmd_echo",
+			Status:  Critical,
+			Message: "Command echo foo | grep -q bar could not run successfully",
+			Metrics: []Metric{{Name: "time", Value: 1, Unit: "s"}},
+		},
+	}
+
+	var b bytes.Buffer
+	PrintNagios(&b, Critical, 1, results)
+
+	lines := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected a summary and a line per check, got %q", b.String())
+	}
+	if strings.Count(lines[0], "|") != 1 || !strings.HasSuffix(lines[0], "| cmd_echo.time=1s") {
+		t.Errorf("expected a single '|' before the performance data, got %s", lines[0])
+	}
+	if strings.Contains(lines[1], "|") {
+		t.Errorf("expected no '|' in the long output, got %s", lines[1])
+	}
+}
@@ -1,19 +1,40 @@
 package main
 
 import (
+	"bufio"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net"
+	"strings"
+	"time"
 )
 
+// Sends results of checks to a monitoring system
+type ResultSender interface {
+	SendResults(results []*Result) error
+}
+
+// Returned by a ResultSender that failed after delivering the first Sent results
+type PartialSendError struct {
+	Sent int
+	Err  error
+}
+
+func (e *PartialSendError) Error() string {
+	return e.Err.Error()
+}
+
+// Client for the client socket of the sensu classic agent
 type SensuClient struct {
 	Address string
 }
 
 type SensuResult struct {
-	Name   string `json:"name"`
-	Status int    `json:"status"`
-	Output string `json:"output"`
+	Name     string `json:"name"`
+	Status   int    `json:"status"`
+	Output   string `json:"output"`
+	Executed int64  `json:"executed"`
 }
 
 func NewSensuClient(address string) *SensuClient {
@@ -22,30 +43,72 @@ func NewSensuClient(address string) *SensuClient {
 	}
 }
 
-func (s *SensuClient) SendResult(checkName string, status Status, message string) error {
-	if status == OK && message == "" {
+// Send results over a single connection
+func (s *SensuClient) SendResults(results []*Result) error {
+	conn, err := net.DialTimeout("tcp", s.Address, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+
+	// Each result is acknowledged, so only the remaining ones need to be sent again
+	for i, r := range results {
+		err = s.Send(conn, reader, NewSensuResult(r))
+		if err != nil {
+			return &PartialSendError{
+				Sent: i,
+				Err:  err,
+			}
+		}
+	}
+
+	return nil
+}
+
+func NewSensuResult(r *Result) *SensuResult {
+	message := r.Message
+	if r.Status == OK && message == "" {
 		message = "Check returned successfully"
 	}
-	result := &SensuResult{
-		Name:   fmt.Sprintf("nhc_%s", checkName),
-		Status: status.RC(),
-		Output: fmt.Sprintf("%s: %s\n", status.String(), message),
+	if len(r.Metrics) > 0 {
+		perfdata := []string{}
+		for _, metric := range r.Metrics {
+			perfdata = append(perfdata, metric.String())
+		}
+		message = fmt.Sprintf("%s | %s", message, strings.Join(perfdata, " "))
+	}
+	return &SensuResult{
+		Name:     fmt.Sprintf("nhc_%s", r.Label),
+		Status:   r.Status.RC(),
+		Output:   fmt.Sprintf("%s: %s\n", r.Status.String(), message),
+		Executed: r.Time.Unix(),
 	}
-	return s.Send(result)
 }
 
-func (s *SensuClient) Send(r *SensuResult) error {
+// Send a single result, and wait for the agent to acknowledge it
+func (s *SensuClient) Send(conn net.Conn, reader *bufio.Reader, r *SensuResult) error {
 	b, err := json.Marshal(r)
 	if err != nil {
 		return err
 	}
 
-	conn, err := net.Dial("tcp", s.Address)
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	_, err = fmt.Fprintln(conn, string(b))
 	if err != nil {
 		return err
 	}
-	defer conn.Close()
 
-	fmt.Fprintln(conn, string(b))
+	reply := make([]byte, 2)
+	_, err = io.ReadFull(reader, reply)
+	if err != nil {
+		return fmt.Errorf("no acknowledgement for %s: %s", r.Name, err.Error())
+	}
+	if string(reply) != "ok" {
+		return fmt.Errorf("result %s was not accepted: %s", r.Name, string(reply))
+	}
+
 	return nil
 }
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// Client for the events API of the sensu go agent
+type SensuGoClient struct {
+	URL      string
+	Interval time.Duration
+	TTL      time.Duration
+	Handlers []string
+	Labels   map[string]string
+	client   *http.Client
+}
+
+type SensuGoEvent struct {
+	Check   SensuGoCheck    `json:"check"`
+	Metrics *SensuGoMetrics `json:"metrics,omitempty"`
+}
+
+type SensuGoMetadata struct {
+	Name        string            `json:"name"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type SensuGoCheck struct {
+	Metadata SensuGoMetadata `json:"metadata"`
+	Status   int             `json:"status"`
+	Output   string          `json:"output"`
+	Interval uint32          `json:"interval,omitempty"`
+	TTL      int64           `json:"ttl,omitempty"`
+	Handlers []string        `json:"handlers,omitempty"`
+	Executed int64           `json:"executed"`
+}
+
+type SensuGoMetrics struct {
+	Handlers []string             `json:"handlers,omitempty"`
+	Points   []SensuGoMetricPoint `json:"points"`
+}
+
+type SensuGoMetricPoint struct {
+	Name      string             `json:"name"`
+	Value     float64            `json:"value"`
+	Timestamp int64              `json:"timestamp"`
+	Tags      []SensuGoMetricTag `json:"tags,omitempty"`
+}
+
+type SensuGoMetricTag struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+func NewSensuGoClient(url string, interval time.Duration, ttl time.Duration, handlers []string, labels map[string]string) (*SensuGoClient, error) {
+	// Sensu go rejects checks of which the ttl does not exceed the interval
+	if ttl > 0 && ttl <= interval {
+		return nil, fmt.Errorf("the sensu ttl %s must be longer than the interval %s", ttl.String(), interval.String())
+	}
+
+	return &SensuGoClient{
+		URL:      url,
+		Interval: interval,
+		TTL:      ttl,
+		Handlers: handlers,
+		Labels:   labels,
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+	}, nil
+}
+
+// Send results, reusing the connection to the agent
+func (s *SensuGoClient) SendResults(results []*Result) error {
+	for i, r := range results {
+		err := s.Send(s.NewEvent(r))
+		if err != nil {
+			return &PartialSendError{
+				Sent: i,
+				Err:  err,
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *SensuGoClient) NewEvent(r *Result) *SensuGoEvent {
+	message := r.Message
+	if r.Status == OK && message == "" {
+		message = "Check returned successfully"
+	}
+
+	labels := map[string]string{
+		"nhc_type": r.Type,
+	}
+	for key, value := range s.Labels {
+		labels[key] = value
+	}
+
+	event := &SensuGoEvent{
+		Check: SensuGoCheck{
+			Metadata: SensuGoMetadata{
+				Name:   fmt.Sprintf("nhc_%s", r.Label),
+				Labels: labels,
+				Annotations: map[string]string{
+					"nhc_argument": r.Argument,
+				},
+			},
+			Status:   r.Status.RC(),
+			Output:   fmt.Sprintf("%s: %s\n", r.Status.String(), message),
+			Interval: uint32(s.Interval.Seconds()),
+			TTL:      int64(s.TTL.Seconds()),
+			Handlers: s.Handlers,
+			Executed: r.Time.Unix(),
+		},
+	}
+
+	if len(r.Metrics) > 0 {
+		event.Metrics = &SensuGoMetrics{
+			Handlers: s.Handlers,
+			Points:   []SensuGoMetricPoint{},
+		}
+		for _, metric := range r.Metrics {
+			point := SensuGoMetricPoint{
+				Name:      fmt.Sprintf("nhc_%s.%s", r.Label, metric.Name),
+				Value:     metric.Value,
+				Timestamp: r.Time.Unix(),
+			}
+			if metric.Unit != "" {
+				point.Tags = []SensuGoMetricTag{{Name: "unit", Value: metric.Unit}}
+			}
+			event.Metrics.Points = append(event.Metrics.Points, point)
+		}
+	}
+
+	return event
+}
+
+func (s *SensuGoClient) Send(e *SensuGoEvent) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.client.Post(s.URL, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Drain the body so the connection can be reused
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected response %s: %s", resp.Status, string(body))
+	}
+
+	return nil
+}
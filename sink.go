@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Delivers results to a ResultSender. Delivery is retried with exponential
+// backoff, and results that could not be delivered are spooled to a directory
+// and delivered on the next run. The spool holds at most SpoolMax results,
+// and results older than SpoolMaxAge are dropped.
+type Sink struct {
+	Name        string
+	Sender      ResultSender
+	Retries     int
+	Backoff     time.Duration
+	SpoolDir    string
+	SpoolMax    int
+	SpoolMaxAge time.Duration
+}
+
+func NewSink(name string, sender ResultSender, retries int, backoff time.Duration, spoolDir string, spoolMax int, spoolMaxAge time.Duration) *Sink {
+	s := &Sink{
+		Name:        name,
+		Sender:      sender,
+		Retries:     retries,
+		Backoff:     backoff,
+		SpoolMax:    spoolMax,
+		SpoolMaxAge: spoolMaxAge,
+	}
+	if spoolDir != "" {
+		s.SpoolDir = filepath.Join(spoolDir, name)
+	}
+	return s
+}
+
+// Deliver the given results, together with any results spooled during earlier runs
+func (s *Sink) Deliver(results []*Result) error {
+	spooled, files, err := s.readSpool()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not read spooled results for %s: %s\n", s.Name, err.Error())
+	}
+
+	batch := append(spooled, results...)
+	if len(batch) == 0 {
+		return nil
+	}
+
+	remaining, err := s.send(batch)
+	if err == nil {
+		for _, file := range files {
+			os.Remove(file)
+		}
+		return nil
+	}
+
+	if s.SpoolDir == "" {
+		return err
+	}
+
+	if filter, ok := s.Sender.(SpoolFilter); ok {
+		remaining = filter.Spooled(remaining)
+	}
+
+	// Results that were acknowledged must not be sent again, so the spooled
+	// files are replaced by the results that remain
+	if s.SpoolMax > 0 && len(remaining) > s.SpoolMax {
+		fmt.Fprintf(os.Stderr, "Dropping %d spooled results for %s, the spool is full\n", len(remaining)-s.SpoolMax, s.Name)
+		remaining = remaining[len(remaining)-s.SpoolMax:]
+	}
+
+	if spoolErr := s.writeSpool(remaining); spoolErr != nil {
+		return fmt.Errorf("%s, and could not spool results: %s", err.Error(), spoolErr.Error())
+	}
+
+	for _, file := range files {
+		os.Remove(file)
+	}
+
+	return fmt.Errorf("%s, spooled %d results to %s", err.Error(), len(remaining), s.SpoolDir)
+}
+
+// Implemented by a ResultSender that only needs some of the results that
+// could not be delivered to be spooled
+type SpoolFilter interface {
+	Spooled(results []*Result) []*Result
+}
+
+// Send the results, and return the ones that were not delivered
+func (s *Sink) send(results []*Result) ([]*Result, error) {
+	backoff := s.Backoff
+	results, err := s.sendOnce(results)
+
+	for attempt := 0; err != nil && attempt < s.Retries; attempt++ {
+		time.Sleep(backoff)
+		backoff *= 2
+		results, err = s.sendOnce(results)
+	}
+
+	return results, err
+}
+
+func (s *Sink) sendOnce(results []*Result) ([]*Result, error) {
+	err := s.Sender.SendResults(results)
+	if partial, ok := err.(*PartialSendError); ok {
+		return results[partial.Sent:], partial.Err
+	} else if err != nil {
+		return results, err
+	}
+	return nil, nil
+}
+
+func (s *Sink) readSpool() ([]*Result, []string, error) {
+	if s.SpoolDir == "" {
+		return nil, nil, nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(s.SpoolDir, "*.json"))
+	if err != nil {
+		return nil, nil, err
+	}
+	sort.Strings(files)
+
+	var expired int
+	results := []*Result{}
+	for _, file := range files {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		batch := []*Result{}
+		if err := json.Unmarshal(b, &batch); err != nil {
+			// Corrupt file, e.g. due to a full disk - there is no point in keeping it
+			fmt.Fprintf(os.Stderr, "Discarding spooled results in %s: %s\n", file, err.Error())
+			os.Remove(file)
+			continue
+		}
+
+		for _, r := range batch {
+			if s.SpoolMaxAge > 0 && time.Since(r.Time) > s.SpoolMaxAge {
+				expired++
+				continue
+			}
+			results = append(results, r)
+		}
+	}
+
+	if expired > 0 {
+		fmt.Fprintf(os.Stderr, "Dropping %d spooled results for %s, older than %s\n", expired, s.Name, s.SpoolMaxAge)
+	}
+
+	return results, files, nil
+}
+
+func (s *Sink) writeSpool(results []*Result) error {
+	if err := os.MkdirAll(s.SpoolDir, 0700); err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(results)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first, so that a partial file is never read
+	name := fmt.Sprintf("%020d-%d", time.Now().UnixNano(), os.Getpid())
+	tmp := filepath.Join(s.SpoolDir, name+".tmp")
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, strings.TrimSuffix(tmp, ".tmp")+".json")
+}
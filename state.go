@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// Persistent state, kept between runs
+type State struct {
+	Checks map[string]*CheckState `json:"checks"`
+}
+
+type CheckState struct {
+	Status    Status    `json:"status"`
+	Message   string    `json:"message"`
+	Since     time.Time `json:"since"`
+	LastRun   time.Time `json:"last_run"`
+	Failures  int       `json:"failures"`
+	Successes int       `json:"successes"`
+
+	// Raw counters reported by the check, e.g. to calculate a rate
+	Counters map[string]uint64 `json:"counters,omitempty"`
+}
+
+// Load the state from the given file. A missing file results in an empty state
+func LoadState(file string) (*State, error) {
+	s := &State{
+		Checks: map[string]*CheckState{},
+	}
+
+	b, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(b, s)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.Checks == nil {
+		s.Checks = map[string]*CheckState{}
+	}
+
+	return s, nil
+}
+
+func (s *State) Save(file string) error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+
+	return WriteFileAtomic(file, b, 0644)
+}
+
+// Record a result, and annotate it with the time of the last state change.
+// A check only starts failing after failuresBeforeAlert consecutive failures,
+// and only clears after successesBeforeClear consecutive successes. Until then,
+// the result is rewritten to keep the previous status.
+func (s *State) Update(r *Result, failuresBeforeAlert int, successesBeforeClear int) {
+	previous, ok := s.Checks[r.Label]
+	if !ok {
+		previous = &CheckState{
+			Status: OK,
+		}
+	}
+
+	current := &CheckState{
+		Status:   r.Status,
+		Message:  r.Message,
+		LastRun:  r.Time,
+		Counters: r.Counters,
+	}
+
+	if r.Status == OK {
+		current.Successes = previous.Successes + 1
+	} else {
+		current.Failures = previous.Failures + 1
+	}
+
+	if r.Status != OK && previous.Status == OK && current.Failures < failuresBeforeAlert {
+		current.Status = OK
+		current.Message = ""
+		r.Message = fmt.Sprintf("Suppressed %s, failure %d of %d before alerting: %s", r.Status.String(), current.Failures, failuresBeforeAlert, r.Message)
+		r.Status = OK
+	} else if r.Status == OK && previous.Status != OK && current.Successes < successesBeforeClear {
+		current.Status = previous.Status
+		current.Message = previous.Message
+		r.Message = fmt.Sprintf("Passed, success %d of %d before clearing: %s", current.Successes, successesBeforeClear, previous.Message)
+		r.Status = previous.Status
+	}
+
+	if !ok || previous.Status != current.Status {
+		r.Changed = true
+		current.Since = r.Time
+	} else {
+		current.Since = previous.Since
+	}
+	r.Since = current.Since
+
+	s.Checks[r.Label] = current
+}
+
+// Return the last result of a check if it ran less than its interval ago,
+// or nil if the check should be run
+func (s *State) Cached(check LabeledCheck) *Result {
+	if s == nil || check.Interval == 0 {
+		return nil
+	}
+
+	previous, ok := s.Checks[check.Label]
+	if !ok || time.Since(previous.LastRun) >= check.Interval {
+		return nil
+	}
+
+	return &Result{
+		Label:    check.Label,
+		Type:     check.Type,
+		Argument: check.Argument,
+		Status:   previous.Status,
+		Message:  previous.Message,
+		Time:     previous.LastRun,
+		Since:    previous.Since,
+	}
+}
+
+// Remove checks that are no longer configured
+func (s *State) Prune(checks []LabeledCheck) {
+	labels := map[string]bool{}
+	for _, check := range checks {
+		labels[check.Label] = true
+	}
+
+	for label := range s.Checks {
+		if !labels[label] {
+			delete(s.Checks, label)
+		}
+	}
+}
+
+// Print how long each check has been in its current state
+func (s *State) Print(w io.Writer, all bool) {
+	labels := []string{}
+	for label := range s.Checks {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	now := time.Now()
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+
+	for _, label := range labels {
+		check := s.Checks[label]
+		if check.Status == OK && !all {
+			continue
+		}
+
+		duration := now.Sub(check.Since).Truncate(time.Second)
+		fmt.Fprintf(tw, "%s\t%s\tsince %s (%s)\t%s\n", check.Status.String(), label, check.Since.Format("2006-01-02 15:04:05"), duration.String(), check.Message)
+	}
+
+	tw.Flush()
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStateUpdate(t *testing.T) {
+	tests := []struct {
+		name                 string
+		failuresBeforeAlert  int
+		successesBeforeClear int
+		statuses             []Status
+		reported             []Status
+		changed              []bool
+	}{
+		{
+			name:                 "defaults",
+			failuresBeforeAlert:  1,
+			successesBeforeClear: 1,
+			statuses:             []Status{OK, Critical, Critical, OK},
+			reported:             []Status{OK, Critical, Critical, OK},
+			changed:              []bool{true, true, false, true},
+		},
+		{
+			// A new check starts out as OK, so the first result is a change
+			name:                 "first failure",
+			failuresBeforeAlert:  1,
+			successesBeforeClear: 1,
+			statuses:             []Status{Warning},
+			reported:             []Status{Warning},
+			changed:              []bool{true},
+		},
+		{
+			name:                 "suppressed failures",
+			failuresBeforeAlert:  3,
+			successesBeforeClear: 1,
+			statuses:             []Status{OK, Critical, Critical, Critical, Critical},
+			reported:             []Status{OK, OK, OK, Critical, Critical},
+			changed:              []bool{true, false, false, true, false},
+		},
+		{
+			// A success resets the consecutive failures
+			name:                 "flapping",
+			failuresBeforeAlert:  2,
+			successesBeforeClear: 1,
+			statuses:             []Status{OK, Critical, OK, Critical, OK},
+			reported:             []Status{OK, OK, OK, OK, OK},
+			changed:              []bool{true, false, false, false, false},
+		},
+		{
+			name:                 "delayed clear",
+			failuresBeforeAlert:  1,
+			successesBeforeClear: 2,
+			statuses:             []Status{Critical, OK, Critical, OK, OK},
+			reported:             []Status{Critical, Critical, Critical, Critical, OK},
+			changed:              []bool{true, false, false, false, true},
+		},
+		{
+			// Changing between failing statuses is not delayed
+			name:                 "escalation",
+			failuresBeforeAlert:  2,
+			successesBeforeClear: 2,
+			statuses:             []Status{OK, Warning, Warning, Critical},
+			reported:             []Status{OK, OK, Warning, Critical},
+			changed:              []bool{true, false, true, true},
+		},
+	}
+
+	for _, test := range tests {
+		state := &State{
+			Checks: map[string]*CheckState{},
+		}
+
+		reported := []Status{}
+		changed := []bool{}
+		start := time.Now()
+
+		for i, status := range test.statuses {
+			r := &Result{
+				Label:   "check",
+				Status:  status,
+				Message: status.String(),
+				Time:    start.Add(time.Duration(i) * time.Minute),
+			}
+			state.Update(r, test.failuresBeforeAlert, test.successesBeforeClear)
+
+			reported = append(reported, r.Status)
+			changed = append(changed, r.Changed)
+
+			if state.Checks["check"].Status != r.Status {
+				t.Errorf("%s: state %s differs from the reported status %s", test.name, state.Checks["check"].Status.String(), r.Status.String())
+			}
+		}
+
+		if !reflect.DeepEqual(reported, test.reported) {
+			t.Errorf("%s: expected statuses %v, got %v", test.name, test.reported, reported)
+		}
+		if !reflect.DeepEqual(changed, test.changed) {
+			t.Errorf("%s: expected changes %v, got %v", test.name, test.changed, changed)
+		}
+	}
+}
+
+func TestStateUpdateSince(t *testing.T) {
+	state := &State{
+		Checks: map[string]*CheckState{},
+	}
+	start := time.Now()
+
+	for i, status := range []Status{Critical, Critical, OK} {
+		r := &Result{
+			Label:  "check",
+			Status: status,
+			Time:   start.Add(time.Duration(i) * time.Minute),
+		}
+		state.Update(r, 1, 2)
+
+		// The second success is needed to clear the check
+		if !r.Since.Equal(start) {
+			t.Errorf("run %d: expected the check to be failing since %s, got %s", i, start, r.Since)
+		}
+	}
+}
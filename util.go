@@ -1,11 +1,31 @@
 package main
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
+	"os/user"
 	"regexp"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
+)
+
+var (
+	fileTypes = map[string]os.FileMode{
+		"file":      0,
+		"directory": os.ModeDir,
+		"symlink":   os.ModeSymlink,
+		"socket":    os.ModeSocket,
+		"pipe":      os.ModeNamedPipe,
+		"device":    os.ModeDevice,
+		"char":      os.ModeDevice | os.ModeCharDevice,
+	}
 )
 
 func AssureExists(file string) (Status, string) {
@@ -38,3 +58,141 @@ func AssureContent(file string, re *regexp.Regexp) (Status, string) {
 
 	return OK, ""
 }
+
+// Parse an octal file mode, including setuid, setgid and sticky bits, e.g. 0400 or 1777
+func ParseMode(mode string) (uint32, error) {
+	i, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil {
+		return 0, err
+	}
+	if i > 07777 {
+		return 0, fmt.Errorf("invalid file mode %s", mode)
+	}
+	return uint32(i), nil
+}
+
+// Validate a file type as accepted by AssureType
+func ParseFileType(fileType string) error {
+	if _, ok := fileTypes[fileType]; !ok {
+		return fmt.Errorf("unknown file type %s", fileType)
+	}
+	return nil
+}
+
+// Check the type of a file, as returned by os.Stat or os.Lstat
+func AssureType(file string, info os.FileInfo, fileType string) (Status, string) {
+	actual := info.Mode() & os.ModeType
+	if actual != fileTypes[fileType] {
+		return Critical, fmt.Sprintf("File %s is not of type %s: %s", file, fileType, info.Mode().String())
+	}
+	return OK, ""
+}
+
+// Check the permission bits of a file, as returned by os.Stat or os.Lstat
+func AssureMode(file string, info os.FileInfo, mode uint32) (Status, string) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return Unknown, fmt.Sprintf("Could not retrieve mode of %s", file)
+	}
+	if stat.Mode&07777 != mode {
+		return Critical, fmt.Sprintf("File %s does not have mode %04o: %04o", file, mode, stat.Mode&07777)
+	}
+	return OK, ""
+}
+
+// Check the owner and group of a file, as returned by os.Stat or os.Lstat.
+// Owner and group can be given by name or numerically, and are skipped if empty
+func AssureOwnership(file string, info os.FileInfo, owner string, group string) (Status, string) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return Unknown, fmt.Sprintf("Could not retrieve ownership of %s", file)
+	}
+
+	if owner != "" {
+		uid, err := lookupUid(owner)
+		if err != nil {
+			return Unknown, fmt.Sprintf("Could not lookup %s: %s", owner, err.Error())
+		}
+		if stat.Uid != uid {
+			return Critical, fmt.Sprintf("File %s is not owned by user %d (%s), but %d", file, uid, owner, stat.Uid)
+		}
+	}
+
+	if group != "" {
+		gid, err := lookupGid(group)
+		if err != nil {
+			return Unknown, fmt.Sprintf("Could not lookup group %s: %s", group, err.Error())
+		}
+		if stat.Gid != gid {
+			return Critical, fmt.Sprintf("File %s is not owned by group %d (%s), but %d", file, gid, group, stat.Gid)
+		}
+	}
+
+	return OK, ""
+}
+
+// Check the sha256 checksum of a file
+func AssureChecksum(file string, checksum string) (Status, string) {
+	handle, err := os.Open(file)
+	if err != nil {
+		return Unknown, fmt.Sprintf("Could not open file %s: %s", file, err.Error())
+	}
+	defer handle.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, handle); err != nil {
+		return Unknown, fmt.Sprintf("Could not read file %s: %s", file, err.Error())
+	}
+
+	actual := hex.EncodeToString(hash.Sum(nil))
+	if actual != strings.ToLower(checksum) {
+		return Critical, fmt.Sprintf("File %s does not match checksum %s: %s", file, checksum, actual)
+	}
+
+	return OK, ""
+}
+
+// Generate a random identifier for a run of go-nhc
+func NewRunID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+func lookupUid(owner string) (uint32, error) {
+	if uid, err := strconv.ParseUint(owner, 10, 32); err == nil {
+		return uint32(uid), nil
+	}
+	u, err := user.Lookup(owner)
+	if err != nil {
+		return 0, err
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	return uint32(uid), err
+}
+
+func lookupGid(group string) (uint32, error) {
+	if gid, err := strconv.ParseUint(group, 10, 32); err == nil {
+		return uint32(gid), nil
+	}
+	g, err := user.LookupGroup(group)
+	if err != nil {
+		return 0, err
+	}
+	gid, err := strconv.ParseUint(g.Gid, 10, 32)
+	return uint32(gid), err
+}
+
+// Write a file through a temporary file in the same directory, so that
+// concurrent runs never see a partial file
+func WriteFileAtomic(file string, b []byte, perm os.FileMode) error {
+	tmp := fmt.Sprintf("%s.%d", file, os.Getpid())
+	if err := ioutil.WriteFile(tmp, b, perm); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, file)
+}
@@ -0,0 +1,70 @@
+// +build linux
+
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+var (
+	ErrTimeout = errors.New("timeout expired")
+)
+
+// Run a command in its own process group and return its standard output and
+// exit code. If the timeout expires, the whole process group is killed and
+// ErrTimeout is returned. A zero timeout means no timeout.
+func RunCommand(name string, args []string, timeout time.Duration) ([]byte, int, error) {
+	cmd := exec.Command(name, args...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, -1, err
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		return nil, -1, err
+	}
+
+	// The output is read before waiting for the command, as required by StdoutPipe
+	output := make(chan []byte, 1)
+	done := make(chan error, 1)
+	go func() {
+		var buffer bytes.Buffer
+		buffer.ReadFrom(stdout)
+		output <- buffer.Bytes()
+		done <- cmd.Wait()
+	}()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case err = <-done:
+	case <-expired:
+		// Negative pid signals the process group
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+
+		// Processes that left the process group can still hold the pipe open,
+		// closing the read end stops reading without waiting for them
+		stdout.Close()
+		return <-output, -1, ErrTimeout
+	}
+
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return <-output, exitErr.ExitCode(), nil
+	} else if err != nil {
+		return <-output, -1, err
+	}
+
+	return <-output, 0, nil
+}
@@ -0,0 +1,79 @@
+// +build linux
+
+package utils
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// List the distinct values of a field in /proc/cpuinfo, e.g. microcode,
+// in order of appearance. linuxproc.CPUInfo only parses a subset of the fields
+func ListCPUInfoValues(file string, key string) ([]string, error) {
+	handle, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer handle.Close()
+
+	result := []string{}
+	seen := map[string]bool{}
+
+	scanner := bufio.NewScanner(handle)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) != key {
+			continue
+		}
+
+		value := strings.TrimSpace(parts[1])
+		if !seen[value] {
+			seen[value] = true
+			result = append(result, value)
+		}
+	}
+
+	return result, scanner.Err()
+}
+
+// Parse a list of cpus as used in sysfs, e.g. 0-3,8-11
+func ParseCPUList(list string) ([]int, error) {
+	result := []int{}
+
+	for _, item := range strings.Split(strings.TrimSpace(list), ",") {
+		if item == "" {
+			continue
+		}
+
+		bounds := strings.SplitN(item, "-", 2)
+		first, err := strconv.Atoi(bounds[0])
+		if err != nil {
+			return nil, err
+		}
+
+		last := first
+		if len(bounds) == 2 {
+			last, err = strconv.Atoi(bounds[1])
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		for cpu := first; cpu <= last; cpu++ {
+			result = append(result, cpu)
+		}
+	}
+
+	return result, nil
+}
+
+// Read a list of cpus from a file in sysfs, e.g. /sys/devices/system/cpu/online
+func ReadCPUList(file string) ([]int, error) {
+	value, err := ReadString(file)
+	if err != nil {
+		return nil, err
+	}
+	return ParseCPUList(value)
+}
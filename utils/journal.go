@@ -0,0 +1,50 @@
+// +build linux
+
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+)
+
+const (
+	journal_socket = "/run/systemd/journal/socket"
+)
+
+// Send an entry to journald using its native protocol, so that fields are kept
+// structured. Field names must consist of uppercase letters, digits and underscores.
+func JournalSend(message string, priority int, fields map[string]string) error {
+	var data bytes.Buffer
+
+	writeJournalField(&data, "MESSAGE", message)
+	writeJournalField(&data, "PRIORITY", fmt.Sprintf("%d", priority))
+	for key, value := range fields {
+		writeJournalField(&data, key, value)
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journal_socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write(data.Bytes())
+	return err
+}
+
+func writeJournalField(w *bytes.Buffer, key string, value string) {
+	if !strings.Contains(value, "\n") {
+		fmt.Fprintf(w, "%s=%s\n", key, value)
+		return
+	}
+
+	// Values containing newlines are prefixed by their length as little endian uint64
+	w.WriteString(key)
+	w.WriteByte('\n')
+	binary.Write(w, binary.LittleEndian, uint64(len(value)))
+	w.WriteString(value)
+	w.WriteByte('\n')
+}
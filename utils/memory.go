@@ -18,10 +18,20 @@ const (
 type MemoryChannel struct {
 	Name  string
 	Dimms []Dimm
+
+	// Error counters of the memory controller. Errors that could not be
+	// attributed to a dimm are only counted in the NoInfo counters
+	CECount       uint64
+	UECount       uint64
+	CENoInfoCount uint64
+	UENoInfoCount uint64
 }
 type Dimm struct {
-	Name string
-	Size uint64
+	Name    string
+	Label   string // Slot on the motherboard, e.g. CPU_SrcID#0_MC#0_Chan#1_DIMM#0
+	Size    uint64
+	CECount uint64
+	UECount uint64
 }
 
 func ListMemoryChannels() ([]MemoryChannel, error) {
@@ -38,8 +48,12 @@ func ListMemoryChannels() ([]MemoryChannel, error) {
 			return nil, err
 		}
 		result = append(result, MemoryChannel{
-			Name:  name,
-			Dimms: dimms,
+			Name:          name,
+			Dimms:         dimms,
+			CECount:       readOptionalUint(fmt.Sprintf("%s/ce_count", file)),
+			UECount:       readOptionalUint(fmt.Sprintf("%s/ue_count", file)),
+			CENoInfoCount: readOptionalUint(fmt.Sprintf("%s/ce_noinfo_count", file)),
+			UENoInfoCount: readOptionalUint(fmt.Sprintf("%s/ue_noinfo_count", file)),
 		})
 	}
 
@@ -78,11 +92,25 @@ func ListDimms(mc string) ([]Dimm, error) {
 			return nil, err
 		}
 
+		label, _ := ReadString(fmt.Sprintf("%s/dimm_label", file))
+
 		result = append(result, Dimm{
-			Name: name,
-			Size: i,
+			Name:    name,
+			Label:   label,
+			Size:    i,
+			CECount: readOptionalUint(fmt.Sprintf("%s/dimm_ce_count", file)),
+			UECount: readOptionalUint(fmt.Sprintf("%s/dimm_ue_count", file)),
 		})
 	}
 
 	return result, nil
 }
+
+// Read a counter that is not provided by every EDAC driver, defaulting to 0
+func readOptionalUint(file string) uint64 {
+	i, err := ReadUint(file)
+	if err != nil {
+		return 0
+	}
+	return i
+}
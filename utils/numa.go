@@ -0,0 +1,70 @@
+// +build linux
+
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	numa_nodes_folder = "/sys/devices/system/node"
+)
+
+type NumaNode struct {
+	Name     string
+	MemTotal uint64 // In bytes
+}
+
+func ListNumaNodes() ([]NumaNode, error) {
+	files, err := filepath.Glob(fmt.Sprintf("%s/node[0-9]*", numa_nodes_folder))
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]NumaNode, 0, len(files))
+	for _, file := range files {
+		total, err := readNodeMemTotal(fmt.Sprintf("%s/meminfo", file))
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, NumaNode{
+			Name:     filepath.Base(file),
+			MemTotal: total,
+		})
+	}
+
+	return result, nil
+}
+
+// Read MemTotal from a node's meminfo, format: 'Node 0 MemTotal: 263898696 kB'
+func readNodeMemTotal(file string) (uint64, error) {
+	handle, err := os.Open(file)
+	if err != nil {
+		return 0, err
+	}
+	defer handle.Close()
+
+	scanner := bufio.NewScanner(handle)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 4 && fields[2] == "MemTotal:" {
+			i, err := strconv.ParseUint(fields[3], 10, 64)
+			if err != nil {
+				return 0, err
+			}
+			return i * 1024, nil
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+
+	return 0, fmt.Errorf("no MemTotal in %s", file)
+}
@@ -0,0 +1,27 @@
+// +build linux
+
+package utils
+
+import (
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// Read a single-value file, e.g. in /sys or /proc, without trailing newline
+func ReadString(file string) (string, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(b), "\n"), nil
+}
+
+// Read a single-value file containing an unsigned integer
+func ReadUint(file string) (uint64, error) {
+	value, err := ReadString(file)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(value), 10, 64)
+}
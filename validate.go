@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/iancoleman/strcase"
+)
+
+// Register the checks in the config file, and return all errors encountered
+func (c *Context) RegisterConfig(lines []ConfigLine) []error {
+	errors := []error{}
+	definitions := map[string]CheckDefinition{}
+	for _, definition := range c.Definitions() {
+		definitions[definition.Flag] = definition
+	}
+
+	for _, line := range lines {
+		definition, ok := definitions[line.Flag]
+		if !ok {
+			// Other flags are allowed as well, e.g. verbose
+			if fApp.GetFlag(line.Flag) == nil {
+				errors = append(errors, fmt.Errorf("%s: unknown check '%s'%s", line.String(), line.Flag, DidYouMean(line.Flag, FlagNames())))
+			}
+			continue
+		}
+
+		count := len(c.checks)
+		err := c.RegisterDefinition(definition, line.Argument)
+		if err != nil {
+			errors = append(errors, fmt.Errorf("%s: %s", line.String(), err.Error()))
+		} else if len(c.checks) > count {
+			c.checks[count].Source = line.String()
+		}
+	}
+
+	return errors
+}
+
+// Parse all checks in the config file without running them, and report all errors
+func Validate(file string) {
+	lines, err := ReadConfig(file)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	c := &Context{
+		checks: []LabeledCheck{},
+	}
+
+	errors := c.RegisterConfig(lines)
+
+	// Dependencies can only be resolved if all checks could be parsed
+	if len(errors) == 0 {
+		if err := c.ResolveDependencies(); err != nil {
+			errors = append(errors, err)
+		}
+	}
+
+	for _, err := range errors {
+		fmt.Println(err.Error())
+	}
+
+	if len(errors) > 0 {
+		fmt.Printf("%d errors found in %s\n", len(errors), file)
+		os.Exit(1)
+	}
+
+	fmt.Printf("%d checks in %s are valid\n", len(c.checks), file)
+	os.Exit(0)
+}
+
+// Print the resolved metadata of a check given on the command line or in the config file
+func Explain(file string, label string) {
+	c := &Context{
+		checks: []LabeledCheck{},
+	}
+
+	err := c.RegisterAll()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	lines, err := ReadConfig(file)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, err := range c.RegisterConfig(lines) {
+		fmt.Fprintln(os.Stderr, err.Error())
+	}
+
+	labels := []string{}
+	for _, check := range c.checks {
+		labels = append(labels, check.Label)
+		if check.Label != label {
+			continue
+		}
+
+		source := check.Source
+		if source == "" {
+			source = "command line"
+		}
+
+		fmt.Printf("label: %s\n", check.Label)
+		fmt.Printf("type: %s\n", check.Type)
+		fmt.Printf("source: %s\n", source)
+		fmt.Printf("argument: %s\n", check.Argument)
+		if check.Metadata != nil {
+			fmt.Println("metadata:")
+			for _, line := range FormatMetadata(check.Metadata) {
+				fmt.Printf("  %s\n", line)
+			}
+		}
+		fmt.Println("common metadata:")
+		for _, line := range FormatMetadata(&check.CommonMetadata) {
+			fmt.Printf("  %s\n", line)
+		}
+		os.Exit(0)
+	}
+
+	fmt.Printf("No check with label %s%s\n", label, DidYouMean(label, labels))
+	os.Exit(1)
+}
+
+// Format each field of a metadata struct as KEY=VALUE
+func FormatMetadata(meta interface{}) []string {
+	result := []string{}
+	target := reflect.ValueOf(meta).Elem()
+
+	for i := 0; i < target.NumField(); i++ {
+		var value string
+
+		switch v := target.Field(i).Interface().(type) {
+		case bool:
+			value = "no"
+			if v {
+				value = "yes"
+			}
+		case []string:
+			value = strings.Join(v, ",")
+		case fmt.Stringer:
+			value = v.String()
+		default:
+			value = fmt.Sprintf("%v", v)
+		}
+
+		result = append(result, fmt.Sprintf("%s=%s", strcase.ToSnake(target.Type().Field(i).Name), value))
+	}
+
+	return result
+}
+
+// Keys accepted by the given metadata structs
+func MetadataKeys(metas ...interface{}) []string {
+	result := []string{}
+	for _, meta := range metas {
+		t := reflect.TypeOf(meta)
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		for i := 0; i < t.NumField(); i++ {
+			result = append(result, strcase.ToSnake(t.Field(i).Name))
+		}
+	}
+	return result
+}
+
+func FlagNames() []string {
+	result := []string{}
+	for _, flag := range fApp.Model().Flags {
+		result = append(result, flag.Name)
+	}
+	return result
+}
+
+// Suggest the candidate closest to the given word, if any is close enough
+func DidYouMean(word string, candidates []string) string {
+	best := ""
+	distance := len(word)/3 + 1
+
+	for _, candidate := range candidates {
+		if d := levenshtein(word, candidate); d <= distance {
+			best = candidate
+			distance = d
+		}
+	}
+
+	if best == "" || best == word {
+		return ""
+	}
+	return fmt.Sprintf(", did you mean '%s'?", best)
+}
+
+func levenshtein(a string, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = previous[j-1] + cost
+			if previous[j]+1 < current[j] {
+				current[j] = previous[j] + 1
+			}
+			if current[j-1]+1 < current[j] {
+				current[j] = current[j-1] + 1
+			}
+		}
+		previous, current = current, previous
+	}
+
+	return previous[len(b)]
+}
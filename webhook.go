@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"text/template"
+	"time"
+)
+
+// Client posting a summary of each run to a http endpoint
+type WebhookClient struct {
+	URL      string
+	Headers  map[string]string
+	Template *template.Template
+	OnChange bool
+	Host     string
+	RunID    string
+	client   *http.Client
+}
+
+type WebhookPayload struct {
+	Host    string    `json:"host"`
+	RunID   string    `json:"run_id"`
+	Time    time.Time `json:"time"`
+	Status  string    `json:"status"`
+	RC      int       `json:"rc"`
+	Checks  int       `json:"checks"`
+	Failing []*Result `json:"failing"`
+	Changed []*Result `json:"changed"`
+}
+
+func NewWebhookClient(url string, headers map[string]string, templateFile string, certFile string, keyFile string, caFile string, onChange bool, runID string) (*WebhookClient, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+
+	w := &WebhookClient{
+		URL:      url,
+		Headers:  headers,
+		OnChange: onChange,
+		Host:     host,
+		RunID:    runID,
+	}
+
+	if templateFile != "" {
+		w.Template, err = template.ParseFiles(templateFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	tlsConfig := &tls.Config{}
+
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if caFile != "" {
+		b, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+	}
+
+	w.client = &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig: tlsConfig,
+		},
+	}
+
+	return w, nil
+}
+
+// Post a single document summarizing the results
+func (w *WebhookClient) SendResults(results []*Result) error {
+	payload := w.NewPayload(results)
+
+	// Changes are only known if a state file is kept
+	if w.OnChange && len(payload.Changed) == 0 {
+		return nil
+	}
+
+	return w.Send(payload)
+}
+
+// Only state changes need to be delivered later, the other results are
+// summarized again by the next run
+func (w *WebhookClient) Spooled(results []*Result) []*Result {
+	if !w.OnChange {
+		return results
+	}
+
+	changed := []*Result{}
+	for _, result := range results {
+		if result.Changed {
+			changed = append(changed, result)
+		}
+	}
+	return changed
+}
+
+// Summarize the results. Results of earlier runs that could not be delivered
+// come first: every state change is listed, but each check only counts with
+// its latest result
+func (w *WebhookClient) NewPayload(results []*Result) *WebhookPayload {
+	var global Status
+
+	payload := &WebhookPayload{
+		Host:    w.Host,
+		RunID:   w.RunID,
+		Time:    time.Now(),
+		Failing: []*Result{},
+		Changed: []*Result{},
+	}
+
+	latest := map[string]int{}
+	for i, result := range results {
+		latest[result.Label] = i
+	}
+
+	for i, result := range results {
+		if result.Changed {
+			payload.Changed = append(payload.Changed, result)
+		}
+		if latest[result.Label] != i {
+			continue
+		}
+		payload.Checks++
+		if result.Status == OK {
+			continue
+		}
+		payload.Failing = append(payload.Failing, result)
+		if result.Status.Compare(global) > 0 {
+			global = result.Status
+		}
+	}
+
+	payload.Status = global.String()
+	payload.RC = global.RC()
+
+	return payload
+}
+
+func (w *WebhookClient) Send(payload *WebhookPayload) error {
+	var body bytes.Buffer
+
+	if w.Template != nil {
+		err := w.Template.Execute(&body, payload)
+		if err != nil {
+			return err
+		}
+	} else {
+		err := json.NewEncoder(&body).Encode(payload)
+		if err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest("POST", w.URL, &body)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range w.Headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	b, _ := ioutil.ReadAll(resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected response %s: %s", resp.Status, string(b))
+	}
+
+	return nil
+}